@@ -0,0 +1,177 @@
+// Package asm is an assembler for the PRU, accepting the
+// pasm dialect used by the rf firmware.
+package asm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Program is an assembled PRU program.
+type Program struct {
+	Code   []uint32
+	Entry  int
+	Labels map[string]int
+}
+
+// A source line holding an instruction.
+type line struct {
+	lineno int
+	addr   int
+	op     string
+	args   []string
+}
+
+type assembler struct {
+	name    string
+	defines map[string]string
+	labels  map[string]int
+	lines   []*line
+	entry   string
+	pass    int
+}
+
+var labelRe = regexp.MustCompile(`^([A-Za-z_]\w*)\s*:`)
+var commentRe = regexp.MustCompile(`(?s)/\*.*?\*/`)
+var identRe = regexp.MustCompile(`[A-Za-z_]\w*`)
+
+// Assemble the source, using name for error messages.
+func Assemble(name string, src string) (*Program, error) {
+	a := &assembler{name: name, defines: make(map[string]string), labels: make(map[string]int)}
+	// Remove block comments, retaining the line breaks.
+	src = commentRe.ReplaceAllStringFunc(src, func(s string) string {
+		return strings.Repeat("\n", strings.Count(s, "\n"))
+	})
+	addr := 0
+	for i, text := range strings.Split(src, "\n") {
+		lineno := i + 1
+		if c := strings.IndexAny(text, ";"); c >= 0 {
+			text = text[:c]
+		}
+		if c := strings.Index(text, "//"); c >= 0 {
+			text = text[:c]
+		}
+		text = strings.TrimSpace(text)
+		if strings.HasPrefix(text, "#") {
+			if err := a.directive(text); err != nil {
+				return nil, a.errorf(lineno, "%v", err)
+			}
+			continue
+		}
+		for {
+			m := labelRe.FindStringSubmatch(text)
+			if m == nil {
+				break
+			}
+			if _, ok := a.labels[m[1]]; ok {
+				return nil, a.errorf(lineno, "%s: duplicate label", m[1])
+			}
+			a.labels[m[1]] = addr
+			text = strings.TrimSpace(text[len(m[0]):])
+		}
+		if len(text) == 0 {
+			continue
+		}
+		f := strings.Fields(text)
+		op := strings.ToUpper(f[0])
+		var args []string
+		if rest := strings.TrimSpace(text[len(f[0]):]); len(rest) > 0 {
+			for _, s := range strings.Split(rest, ",") {
+				args = append(args, strings.TrimSpace(a.expand(s)))
+			}
+		}
+		switch op {
+		case ".ORIGIN":
+			v, err := a.value(strings.Join(args, ","))
+			if err != nil {
+				return nil, a.errorf(lineno, "%v", err)
+			}
+			if int(v) < addr {
+				return nil, a.errorf(lineno, ".origin %d is before current address %d", v, addr)
+			}
+			addr = int(v)
+			continue
+		case ".ENTRYPOINT":
+			if len(args) != 1 {
+				return nil, a.errorf(lineno, ".entrypoint requires a label")
+			}
+			a.entry = args[0]
+			continue
+		}
+		l := &line{lineno: lineno, addr: addr, op: op, args: args}
+		a.lines = append(a.lines, l)
+		// Determine the size of the instruction.
+		code, err := a.encode(l)
+		if err != nil {
+			return nil, a.errorf(lineno, "%v", err)
+		}
+		addr += len(code)
+	}
+	p := &Program{Code: make([]uint32, addr), Labels: a.labels}
+	a.pass = 1
+	for _, l := range a.lines {
+		code, err := a.encode(l)
+		if err != nil {
+			return nil, a.errorf(l.lineno, "%v", err)
+		}
+		copy(p.Code[l.addr:], code)
+	}
+	if len(a.entry) > 0 {
+		e, ok := a.labels[a.entry]
+		if !ok {
+			return nil, fmt.Errorf("%s: entrypoint %s: undefined label", name, a.entry)
+		}
+		p.Entry = e
+	}
+	return p, nil
+}
+
+func (a *assembler) errorf(lineno int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", a.name, lineno, fmt.Sprintf(format, args...))
+}
+
+// directive handles preprocessor directives.
+func (a *assembler) directive(text string) error {
+	f := strings.Fields(text)
+	switch f[0] {
+	case "#define":
+		if len(f) < 2 {
+			return fmt.Errorf("#define requires a name")
+		}
+		a.defines[f[1]] = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[len("#define"):]), f[1]))
+		return nil
+	}
+	return fmt.Errorf("%s: unsupported directive", f[0])
+}
+
+// expand substitutes defined names.
+func (a *assembler) expand(s string) string {
+	for i := 0; i < 10; i++ {
+		n := identRe.ReplaceAllStringFunc(s, func(id string) string {
+			if d, ok := a.defines[id]; ok {
+				return d
+			}
+			return id
+		})
+		if n == s {
+			break
+		}
+		s = n
+	}
+	return s
+}
+
+// value evaluates an expression. Labels that are not yet defined
+// are treated as 0 in the first pass.
+func (a *assembler) value(s string) (int64, error) {
+	return eval(s, func(name string) (int64, bool) {
+		if v, ok := a.labels[name]; ok {
+			return int64(v), true
+		}
+		if a.pass == 0 {
+			return 0, true
+		}
+		return 0, false
+	})
+}
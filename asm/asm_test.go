@@ -0,0 +1,92 @@
+package asm
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var wordRe = regexp.MustCompile(`0x([0-9a-f]{8}),`)
+
+// readWords returns the words of a generated Go image (if goSrc is true)
+// or of a file of hex words.
+func readWords(t *testing.T, name string, goSrc bool) []uint32 {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var hex []string
+	if goSrc {
+		for _, m := range wordRe.FindAllStringSubmatch(string(data), -1) {
+			hex = append(hex, m[1])
+		}
+	} else {
+		hex = strings.Fields(string(data))
+	}
+	var words []uint32
+	for _, h := range hex {
+		w, err := strconv.ParseUint(h, 16, 32)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		words = append(words, uint32(w))
+	}
+	return words
+}
+
+// TestFirmware assembles each of the firmware sources, and checks that
+// the code is identical to the checked in images.
+func TestFirmware(t *testing.T) {
+	files, err := filepath.Glob("../io/*.p")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no firmware sources found")
+	}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		p, err := Assemble(f, string(src))
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		base := strings.TrimSuffix(f, ".p")
+		for _, img := range []struct {
+			name  string
+			goSrc bool
+		}{{base + "_img.go", true}, {base + ".img", false}} {
+			words := readWords(t, img.name, img.goSrc)
+			if len(words) != len(p.Code) {
+				t.Errorf("%s: %d words, assembled %d", img.name, len(words), len(p.Code))
+				continue
+			}
+			for i, w := range words {
+				if w != p.Code[i] {
+					t.Errorf("%s: word %d is 0x%08x, assembled 0x%08x", img.name, i, w, p.Code[i])
+				}
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, src := range []string{
+		"FOO r1, r2",
+		"QBA Missing",
+		"MOV r1",
+		"ADD r32, r1, 1",
+		"Dup:\nDup:\n",
+	} {
+		if _, err := Assemble("test.p", src); err == nil {
+			t.Errorf("%q: no error", src)
+		}
+	}
+}
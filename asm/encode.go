@@ -0,0 +1,396 @@
+package asm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ALU operations.
+var aluOps = map[string]uint32{
+	"ADD": 0, "ADC": 1, "SUB": 2, "SUC": 3, "LSL": 4, "LSR": 5, "RSB": 6, "RSC": 7,
+	"AND": 8, "OR": 9, "XOR": 10, "NOT": 11, "MIN": 12, "MAX": 13, "CLR": 14, "SET": 15,
+}
+
+// Quick branch conditions, comparing the second operand against the register.
+var branchOps = map[string]uint32{
+	"QBGT": 1, "QBEQ": 2, "QBGE": 3, "QBLT": 4, "QBNE": 5, "QBLE": 6, "QBA": 7,
+}
+
+// Register field selectors.
+var fields = map[string]uint32{
+	"b0": 0, "b1": 1, "b2": 2, "b3": 3, "w0": 4, "w1": 5, "w2": 6,
+}
+
+// reg is a register operand.
+type reg struct {
+	num uint32
+	sel uint32 // Field selector, 7 is the full register.
+	bit int    // Bit selected using .tN, or -1
+}
+
+// field returns the encoded register and field selector.
+func (r reg) field() uint32 {
+	return r.sel<<5 | r.num
+}
+
+var regRe = regexp.MustCompile(`(?i)^&?r(\d+)(?:\.(b[0-3]|w[0-2]|t\d+))?$`)
+
+// parseReg parses a register operand, returning false if it is not a register.
+func parseReg(s string) (reg, bool, error) {
+	m := regRe.FindStringSubmatch(s)
+	if m == nil {
+		return reg{}, false, nil
+	}
+	r := reg{sel: 7, bit: -1}
+	n, _ := strconv.Atoi(m[1])
+	if n > 31 {
+		return r, true, fmt.Errorf("%s: illegal register", s)
+	}
+	r.num = uint32(n)
+	f := strings.ToLower(m[2])
+	if strings.HasPrefix(f, "t") {
+		b, _ := strconv.Atoi(f[1:])
+		if b > 31 {
+			return r, true, fmt.Errorf("%s: illegal bit", s)
+		}
+		r.bit = b
+	} else if len(f) > 0 {
+		r.sel = fields[f]
+	}
+	return r, true, nil
+}
+
+// register parses an operand that must be a register.
+func register(s string) (reg, error) {
+	r, ok, err := parseReg(s)
+	if err != nil {
+		return r, err
+	}
+	if !ok {
+		return r, fmt.Errorf("%s: register expected", s)
+	}
+	if r.bit >= 0 {
+		return r, fmt.Errorf("%s: bit selector not allowed", s)
+	}
+	return r, nil
+}
+
+// operand parses a register or an immediate value less than or equal to max.
+// The encoded value and the immediate flag are returned.
+func (a *assembler) operand(s string, max int64) (uint32, uint32, error) {
+	r, ok, err := parseReg(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ok {
+		if r.bit >= 0 {
+			return 0, 0, fmt.Errorf("%s: bit selector not allowed", s)
+		}
+		return r.field(), 0, nil
+	}
+	v, err := a.value(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if v < 0 || v > max {
+		return 0, 0, fmt.Errorf("%s: value %d out of range (0-%d)", s, v, max)
+	}
+	return uint32(v), 1, nil
+}
+
+// target returns the word offset from the instruction to the branch target.
+func (a *assembler) target(l *line, s string) (uint32, error) {
+	v, err := a.value(s)
+	if err != nil {
+		return 0, err
+	}
+	off := v - int64(l.addr)
+	if a.pass > 0 && (off < -512 || off > 511) {
+		return 0, fmt.Errorf("%s: branch out of range", s)
+	}
+	return uint32(off) & 0x3FF, nil
+}
+
+func (a *assembler) nargs(l *line, n ...int) error {
+	for _, c := range n {
+		if len(l.args) == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: wrong number of operands", l.op)
+}
+
+func alu(op, io, op2, rs1, rd uint32) uint32 {
+	return op<<25 | io<<24 | op2<<16 | rs1<<8 | rd
+}
+
+func ldi(rd reg, v uint32) uint32 {
+	return 1<<29 | 2<<25 | (v&0xFFFF)<<8 | rd.field()
+}
+
+func qb(cond, off, io, op2, rs1 uint32) uint32 {
+	return 1<<30 | cond<<27 | (off>>8)<<25 | io<<24 | op2<<16 | rs1<<8 | off&0xFF
+}
+
+func bitTest(test, off, io, op2, rs1 uint32) uint32 {
+	return 6<<29 | test<<27 | (off>>8)<<25 | io<<24 | op2<<16 | rs1<<8 | off&0xFF
+}
+
+// encode returns the instruction words for a source line.
+func (a *assembler) encode(l *line) ([]uint32, error) {
+	if op, ok := aluOps[l.op]; ok {
+		return a.encodeAlu(l, op)
+	}
+	if cond, ok := branchOps[l.op]; ok {
+		if cond == 7 {
+			if err := a.nargs(l, 1); err != nil {
+				return nil, err
+			}
+			off, err := a.target(l, l.args[0])
+			return []uint32{qb(cond, off, 1, 0, 0)}, err
+		}
+		if err := a.nargs(l, 3); err != nil {
+			return nil, err
+		}
+		off, err := a.target(l, l.args[0])
+		if err != nil {
+			return nil, err
+		}
+		rs1, err := register(l.args[1])
+		if err != nil {
+			return nil, err
+		}
+		op2, io, err := a.operand(l.args[2], 255)
+		return []uint32{qb(cond, off, io, op2, rs1.field())}, err
+	}
+	switch l.op {
+	case "MOV", "LDI":
+		if err := a.nargs(l, 2); err != nil {
+			return nil, err
+		}
+		rd, err := register(l.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if rs, ok, err := parseReg(l.args[1]); err != nil {
+			return nil, err
+		} else if ok {
+			if l.op == "LDI" || rs.bit >= 0 {
+				return nil, fmt.Errorf("%s: immediate value expected", l.args[1])
+			}
+			// Register moves are encoded as an AND of the source with itself.
+			return []uint32{alu(aluOps["AND"], 0, rs.field(), rs.field(), rd.field())}, nil
+		}
+		v, err := a.value(l.args[1])
+		if err != nil {
+			return nil, err
+		}
+		if v < 0 {
+			v &= 0xFFFFFFFF
+		}
+		if v <= 0xFFFF {
+			return []uint32{ldi(rd, uint32(v))}, nil
+		}
+		if l.op == "LDI" || rd.sel != 7 || v > 0xFFFFFFFF {
+			return nil, fmt.Errorf("%s: value out of range", l.args[1])
+		}
+		lo, hi := rd, rd
+		lo.sel, hi.sel = fields["w0"], fields["w2"]
+		return []uint32{ldi(lo, uint32(v)), ldi(hi, uint32(v>>16))}, nil
+	case "QBBC", "QBBS":
+		if err := a.nargs(l, 2, 3); err != nil {
+			return nil, err
+		}
+		off, err := a.target(l, l.args[0])
+		if err != nil {
+			return nil, err
+		}
+		return a.encodeBitTest(l, l.op == "QBBS", off, l.args[1:])
+	case "WBC", "WBS":
+		// Wait loops are encoded as a branch to the same instruction.
+		if err := a.nargs(l, 1, 2); err != nil {
+			return nil, err
+		}
+		return a.encodeBitTest(l, l.op == "WBC", 0, l.args)
+	case "LBBO", "SBBO", "LBCO", "SBCO":
+		return a.encodeMem(l)
+	case "JMP", "JAL":
+		if err := a.nargs(l, map[string]int{"JMP": 1, "JAL": 2}[l.op]); err != nil {
+			return nil, err
+		}
+		t := l.args[len(l.args)-1]
+		op2, io, err := a.operand(t, 0xFFFF)
+		if err != nil {
+			return nil, err
+		}
+		if io != 0 {
+			op2 <<= 8
+		} else {
+			op2 <<= 16
+		}
+		w := 1<<29 | io<<24 | op2
+		if l.op == "JAL" {
+			rd, err := register(l.args[0])
+			if err != nil {
+				return nil, err
+			}
+			w |= 1<<25 | rd.field()
+		}
+		return []uint32{w}, nil
+	case "HALT":
+		if err := a.nargs(l, 0); err != nil {
+			return nil, err
+		}
+		return []uint32{1<<29 | 5<<25}, nil
+	case "SLP":
+		if err := a.nargs(l, 1); err != nil {
+			return nil, err
+		}
+		v, err := a.value(l.args[0])
+		return []uint32{1<<29 | 15<<25 | uint32(v&1)<<23}, err
+	}
+	return nil, fmt.Errorf("%s: unknown instruction", l.op)
+}
+
+// encodeAlu encodes the arithmetic and logical instructions. SET and CLR
+// also accept a destination and bit, or a register with a bit selector.
+func (a *assembler) encodeAlu(l *line, op uint32) ([]uint32, error) {
+	args := l.args
+	if l.op == "SET" || l.op == "CLR" {
+		switch len(args) {
+		case 1:
+			r, ok, err := parseReg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if !ok || r.bit < 0 {
+				return nil, fmt.Errorf("%s: register bit expected", args[0])
+			}
+			return []uint32{alu(op, 1, uint32(r.bit), r.field(), r.field())}, nil
+		case 2:
+			args = []string{args[0], args[0], args[1]}
+		}
+	}
+	if l.op == "NOT" {
+		if err := a.nargs(l, 2); err != nil {
+			return nil, err
+		}
+		args = []string{args[0], args[1], args[1]}
+	}
+	if len(args) != 3 {
+		return nil, fmt.Errorf("%s: wrong number of operands", l.op)
+	}
+	rd, err := register(args[0])
+	if err != nil {
+		return nil, err
+	}
+	rs1, err := register(args[1])
+	if err != nil {
+		return nil, err
+	}
+	op2, io, err := a.operand(args[2], 255)
+	return []uint32{alu(op, io, op2, rs1.field(), rd.field())}, err
+}
+
+// encodeBitTest encodes a branch on a bit being set or clear. The bit
+// is either a separate operand, or a bit selector on the register.
+func (a *assembler) encodeBitTest(l *line, set bool, off uint32, args []string) ([]uint32, error) {
+	test := uint32(1)
+	if set {
+		test = 2
+	}
+	r, ok, err := parseReg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: register expected", args[0])
+	}
+	if len(args) == 1 {
+		if r.bit < 0 {
+			return nil, fmt.Errorf("%s: register bit expected", args[0])
+		}
+		return []uint32{bitTest(test, off, 1, uint32(r.bit), r.field())}, nil
+	}
+	if r.bit >= 0 {
+		return nil, fmt.Errorf("%s: bit selector not allowed", args[0])
+	}
+	op2, io, err := a.operand(args[1], 31)
+	if err != nil {
+		return nil, err
+	}
+	return []uint32{bitTest(test, off, io, op2, r.field())}, nil
+}
+
+var burstRe = regexp.MustCompile(`(?i)^(?:r0\.)?b([0-3])$`)
+var constRe = regexp.MustCompile(`(?i)^c(\d+)$`)
+
+// encodeMem encodes the memory load and store instructions. LBCO and SBCO
+// use an entry from the constant table (c0-c31) as the base address.
+func (a *assembler) encodeMem(l *line) ([]uint32, error) {
+	if err := a.nargs(l, 4); err != nil {
+		return nil, err
+	}
+	rx, err := register(l.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if rx.sel > 3 && rx.sel != 7 {
+		return nil, fmt.Errorf("%s: word selector not allowed", l.args[0])
+	}
+	start := uint32(0)
+	if rx.sel <= 3 {
+		start = rx.sel
+	}
+	var base uint32
+	if l.op == "LBCO" || l.op == "SBCO" {
+		m := constRe.FindStringSubmatch(l.args[1])
+		if m == nil {
+			return nil, fmt.Errorf("%s: constant table entry expected", l.args[1])
+		}
+		c, _ := strconv.Atoi(m[1])
+		if c > 31 {
+			return nil, fmt.Errorf("%s: constant table entry out of range", l.args[1])
+		}
+		base = uint32(c)
+	} else {
+		rb, err := register(l.args[1])
+		if err != nil {
+			return nil, err
+		}
+		if rb.sel != 7 {
+			return nil, fmt.Errorf("%s: field selector not allowed", l.args[1])
+		}
+		base = rb.num
+	}
+	off, io, err := a.operand(l.args[2], 255)
+	if err != nil {
+		return nil, err
+	}
+	var n uint32
+	if m := burstRe.FindStringSubmatch(l.args[3]); m != nil {
+		b, _ := strconv.Atoi(m[1])
+		n = 124 + uint32(b)
+	} else {
+		v, err := a.value(l.args[3])
+		if err != nil {
+			return nil, err
+		}
+		if v < 1 || v > 124 {
+			return nil, fmt.Errorf("%s: length %d out of range (1-124)", l.args[3], v)
+		}
+		n = uint32(v - 1)
+	}
+	w := (n>>4)<<25 | io<<24 | off<<16 | ((n>>1)&7)<<13 | base<<8 | (n&1)<<7 | start<<5 | rx.num
+	if l.op[2:] == "BO" {
+		w |= 7 << 29
+	} else {
+		w |= 4 << 29
+	}
+	if l.op[0] == 'L' {
+		w |= 1 << 28
+	}
+	return []uint32{w}, nil
+}
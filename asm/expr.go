@@ -0,0 +1,152 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parser for integer expressions.
+type expr struct {
+	s      string
+	pos    int
+	lookup func(string) (int64, bool)
+}
+
+// eval evaluates an expression, using lookup to resolve names.
+func eval(s string, lookup func(string) (int64, bool)) (int64, error) {
+	e := &expr{s: s, lookup: lookup}
+	v, err := e.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	e.space()
+	if e.pos != len(e.s) {
+		return 0, fmt.Errorf("%s: unexpected '%s'", s, e.s[e.pos:])
+	}
+	return v, nil
+}
+
+// Binary operators and their precedence.
+var binops = []struct {
+	op   string
+	prec int
+}{
+	{"<<", 5}, {">>", 5}, {"*", 6}, {"/", 6}, {"%", 6},
+	{"+", 4}, {"-", 4}, {"&", 3}, {"^", 2}, {"|", 1},
+}
+
+func (e *expr) space() {
+	for e.pos < len(e.s) && unicode.IsSpace(rune(e.s[e.pos])) {
+		e.pos++
+	}
+}
+
+// binary parses operators with precedence greater than min.
+func (e *expr) binary(min int) (int64, error) {
+	v, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		e.space()
+		found := false
+		for _, b := range binops {
+			if b.prec > min && strings.HasPrefix(e.s[e.pos:], b.op) {
+				e.pos += len(b.op)
+				r, err := e.binary(b.prec)
+				if err != nil {
+					return 0, err
+				}
+				if v, err = apply(b.op, v, r); err != nil {
+					return 0, err
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return v, nil
+		}
+	}
+}
+
+func apply(op string, a, b int64) (int64, error) {
+	switch op {
+	case "<<":
+		return a << uint(b), nil
+	case ">>":
+		return a >> uint(b), nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("divide by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "&":
+		return a & b, nil
+	case "^":
+		return a ^ b, nil
+	}
+	return a | b, nil
+}
+
+func (e *expr) unary() (int64, error) {
+	e.space()
+	if e.pos >= len(e.s) {
+		return 0, fmt.Errorf("%s: missing value", e.s)
+	}
+	switch c := e.s[e.pos]; {
+	case c == '-' || c == '~' || c == '+':
+		e.pos++
+		v, err := e.unary()
+		if c == '-' {
+			v = -v
+		} else if c == '~' {
+			v = ^v
+		}
+		return v, err
+	case c == '(':
+		e.pos++
+		v, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		e.space()
+		if e.pos >= len(e.s) || e.s[e.pos] != ')' {
+			return 0, fmt.Errorf("%s: missing ')'", e.s)
+		}
+		e.pos++
+		return v, nil
+	case c >= '0' && c <= '9':
+		start := e.pos
+		for e.pos < len(e.s) && isIdent(e.s[e.pos]) {
+			e.pos++
+		}
+		return strconv.ParseInt(e.s[start:e.pos], 0, 64)
+	case isIdent(c):
+		start := e.pos
+		for e.pos < len(e.s) && isIdent(e.s[e.pos]) {
+			e.pos++
+		}
+		name := e.s[start:e.pos]
+		if v, ok := e.lookup(name); ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("%s: undefined", name)
+	}
+	return 0, fmt.Errorf("%s: unexpected '%c'", e.s, e.s[e.pos])
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,97 @@
+// Program to assemble PRU firmware and generate Go source holding the image.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aamcrae/rf/asm"
+	"github.com/aamcrae/rf/io"
+)
+
+var output = flag.String("o", "", "Output Go source file (default <name>_img.go)")
+var pkg = flag.String("p", "main", "Go package name")
+var name = flag.String("n", "", "Variable name (default <name>_img)")
+var img = flag.String("img", "", "Optional file for writing the image as hex words")
+var fw = flag.String("fw", "", "Optional file for writing a loadable firmware image")
+var fwType = flag.String("type", "", "Firmware type (rx, tx or rxmulti)")
+var layout = flag.Int("layout", 1, "Firmware parameter layout")
+var version = flag.Int("version", 1, "Firmware version")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("usage: assemble [flags] file.p")
+	}
+	src := flag.Arg(0)
+	base := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	if len(*name) == 0 {
+		*name = base + "_img"
+	}
+	if len(*output) == 0 {
+		*output = base + "_img.go"
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	p, err := asm.Assemble(src, string(data))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if p.Entry != 0 {
+		log.Fatalf("%s: entrypoint must be at address 0", src)
+	}
+	var s strings.Builder
+	fmt.Fprintf(&s, "// Code generated from %s by assemble. DO NOT EDIT.\n\n", filepath.Base(src))
+	fmt.Fprintf(&s, "package %s\n\n", *pkg)
+	fmt.Fprintf(&s, "var %s = []uint32{\n", *name)
+	for _, w := range p.Code {
+		fmt.Fprintf(&s, "\t0x%08x,\n", w)
+	}
+	fmt.Fprintf(&s, "}\n")
+	if err := os.WriteFile(*output, []byte(s.String()), 0644); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*img) > 0 {
+		s.Reset()
+		for _, w := range p.Code {
+			fmt.Fprintf(&s, "%08x\n", w)
+		}
+		if err := os.WriteFile(*img, []byte(s.String()), 0644); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if len(*fw) > 0 {
+		if err := writeFirmware(p.Code); err != nil {
+			log.Fatalf("%s: %v", *fw, err)
+		}
+	}
+}
+
+func writeFirmware(code []uint32) error {
+	f := &io.Firmware{Layout: uint32(*layout), Version: uint32(*version), Code: code}
+	switch *fwType {
+	case "rx":
+		f.Type = io.FirmwareRx
+	case "tx":
+		f.Type = io.FirmwareTx
+	case "rxmulti":
+		f.Type = io.FirmwareRxMulti
+	default:
+		return fmt.Errorf("firmware type must be rx, tx or rxmulti")
+	}
+	out, err := os.Create(*fw)
+	if err != nil {
+		return err
+	}
+	if err := f.Write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
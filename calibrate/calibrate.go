@@ -0,0 +1,55 @@
+// Program to measure the timing errors of a transmitter looped back to a receiver.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aamcrae/rf/io"
+)
+
+var gpio = flag.Int("gpio", 15, "Output GPIO number") // PRU unit 0 P8_11
+var rxGpio = flag.Int("rxgpio", 5, "Receiver input GPIO number")
+var txType = flag.String("tx", io.DefaultBackend, "Transmitter type (pru, file:<name>, sim, emu, null)")
+var rxType = flag.String("rx", io.DefaultBackend, "Receiver type (pru, file:<name>, sim, emu:<name>, null)")
+var repeats = flag.Int("repeat", 10, "Number of times the calibration pattern is sent")
+var output = flag.String("output", "/etc/rf-calibration", "Calibration profile to write")
+var activeLow = flag.Bool("active-low", false, "Transmitter output is low while transmitting")
+var idleHigh = flag.Bool("idle-high", false, "Leave the transmitter output high between transmissions")
+
+func main() {
+	flag.Parse()
+	tx, err := io.OpenSink(*txType, uint(*gpio))
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	defer tx.Close()
+	if *activeLow || *idleHigh {
+		if err := io.SetPolarity(tx, io.Polarity{ActiveLow: *activeLow, IdleHigh: *idleHigh}); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	rx, err := io.OpenSource(*rxType, uint(*rxGpio))
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	defer rx.Close()
+	c, err := io.Calibrate(tx, rx, io.CalibrationPattern, *repeats)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	fmt.Printf("High periods %s, low periods %s (%s)\n", c.Offset+c.Bias, c.Offset-c.Bias, c)
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := c.Write(f); err != nil {
+		log.Fatalf("%s: %v", *output, err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("%s: %v", *output, err)
+	}
+	log.Printf("Calibration written to %s", *output)
+}
@@ -12,15 +12,23 @@ import (
 var verbose = flag.Bool("v", false, "Log more information")
 var samples = flag.Int("samples", 500, "Number of samples")
 var gpio = flag.Int("gpio", 5, "Output GPIO number") // PRU Unit 1, P8_42
+var rxType = flag.String("rx", io.DefaultBackend, "Receiver type (pru, file:<name>, sim, emu:<name>, null)")
+var firmware = flag.String("firmware", "", "Optional file of PRU firmware to run")
 
 func main() {
 	flag.Parse()
 
-	inp, err := io.NewReceiver(uint(*gpio))
+	inp, err := io.OpenSource(*rxType, uint(*gpio))
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
-	tm, err := inp.Read(*samples)
+	defer inp.Close()
+	if len(*firmware) > 0 {
+		if err := io.LoadFirmware(inp, *firmware); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	tm, err := io.Capture(inp, *samples)
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
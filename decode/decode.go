@@ -16,6 +16,7 @@ import (
 
 var verbose = flag.Bool("v", false, "Log more information")
 var gap = flag.Int("gap", 4000, "Inter-message gap time")
+var gapLevel = flag.String("gaplevel", "low", "Level of the gap between messages (low, high for inverted receivers, or auto)")
 var input = flag.String("input", "", "Input file to be read")
 var output = flag.String("output", "", "File for writing message strings")
 var tolerance = flag.Int("tolerance", 20, "Percent tolerance")
@@ -26,6 +27,8 @@ var base_time = flag.Int("base", 0, "Microseconds for bit period")
 var output_limit = flag.Int("limit", 1, "Number of output messages to save")
 var capture = flag.Int("capture", 500, "Number of signals to capture")
 var gpio = flag.Int("gpio", 15, "Input GPIO number for capture")
+var rxType = flag.String("rx", io.DefaultBackend, "Receiver type for capture (pru, file:<name>, sim, emu:<name>, null)")
+var firmware = flag.String("firmware", "", "Optional file of PRU firmware to run")
 
 type msg struct {
 	m     *message.Message
@@ -57,6 +60,10 @@ func main() {
 	l.Gap = *gap
 	l.MinLen = *min_msg
 	l.MaxLen = *max_msg
+	l.GapLevel, err = message.ParseGapLevel(*gapLevel)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 	raw := l.Decode(timings)
 	if len(raw) == 0 {
 		log.Fatalf("No messages found to process")
@@ -125,12 +132,17 @@ func main() {
 }
 
 func rxCapture(max int) ([]int, error) {
-	inp, err := io.NewReceiver(uint(*gpio))
+	inp, err := io.OpenSource(*rxType, uint(*gpio))
 	if err != nil {
 		return nil, err
 	}
 	defer inp.Close()
-	dm, err := inp.Read(max)
+	if len(*firmware) > 0 {
+		if err := io.LoadFirmware(inp, *firmware); err != nil {
+			return nil, err
+		}
+	}
+	dm, err := io.Capture(inp, max)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,471 @@
+// Emulator for the subset of the PRU instruction set used by the rf firmware.
+
+package emu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const ClockRate = 200000000 // 200MHz PRU clock
+const RamSize = 8192        // Size of unit data RAM
+
+// Industrial Ethernet Peripheral (IEP) timer, accessed via constant table entry c26.
+const (
+	IEPBase  = 0x2E000 // Address of the IEP registers
+	IEPSize  = 0x400   // Size of the IEP register block
+	IEPCfg   = 0x00    // Global configuration register
+	IEPCount = 0x0C    // Counter register
+)
+
+// Timing holds the cycle counts for memory access instructions.
+// All other instructions take a single cycle.
+type Timing struct {
+	Load  int // Cycles for LBBO/LBCO, plus one for each extra 4 bytes.
+	Store int // Cycles for SBBO/SBCO, plus one for each extra 4 bytes.
+}
+
+// DefaultTiming approximates the access time of the unit data RAM.
+var DefaultTiming = Timing{Load: 3, Store: 2}
+
+// Edge is a change of the r30 output register.
+type Edge struct {
+	Cycle uint64
+	Value uint32
+}
+
+// PRU is an emulated PRU unit.
+type PRU struct {
+	Reg    [32]uint32
+	PC     uint32
+	Cycle  uint64
+	Carry  bool
+	Halted bool
+	Ram    []byte
+	Order  binary.ByteOrder
+	Timing Timing
+	Edges  []Edge     // Changes of r30
+	Start  uint32     // Value of r30 when the unit was reset
+	Const  [32]uint32 // Constant table used by LBCO/SBCO
+
+	Input func(cycle uint64) uint32 // Supplies the r31 input bits
+	Event func(event int)           // Called when an event is signalled via r31
+
+	code []uint32
+
+	iepCfg   uint32 // IEP configuration
+	iepCount uint32 // IEP counter value at iepCycle
+	iepCycle uint64
+}
+
+func New() *PRU {
+	p := new(PRU)
+	p.Ram = make([]byte, RamSize)
+	p.Order = binary.LittleEndian
+	p.Timing = DefaultTiming
+	p.Const[24] = 0 // Unit data RAM
+	p.Const[26] = IEPBase
+	return p
+}
+
+// Load the program and reset the unit.
+func (p *PRU) Load(code []uint32) {
+	p.code = code
+	p.Reset()
+}
+
+// Reset the registers, program counter and cycle counter.
+// The output register r30 is kept, as the outputs hold their level.
+func (p *PRU) Reset() {
+	p.Reg = [32]uint32{30: p.Reg[30]}
+	p.Start = p.Reg[30]
+	p.PC = 0
+	p.Cycle = 0
+	p.Carry = false
+	p.Halted = false
+	p.Edges = nil
+	p.iepCfg = 0
+	p.iepCount = 0
+	p.iepCycle = 0
+}
+
+// Time returns the elapsed time of the program.
+func (p *PRU) Time() time.Duration {
+	return Duration(p.Cycle)
+}
+
+// Run the program until it halts, or the cycle count has been reached.
+func (p *PRU) Run(until uint64) error {
+	for !p.Halted && p.Cycle < until {
+		if err := p.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes a single instruction.
+func (p *PRU) Step() error {
+	if p.Halted {
+		return nil
+	}
+	if int(p.PC) >= len(p.code) {
+		return fmt.Errorf("PC %d outside program", p.PC)
+	}
+	op := p.code[p.PC]
+	next := p.PC + 1
+	cycles := 1
+	switch op >> 29 {
+	case 0: // Arithmetic and logic
+		a := p.get(byte(op >> 8))
+		var b uint32
+		if op&(1<<24) != 0 {
+			b = (op >> 16) & 0xFF
+		} else {
+			b = p.get(byte(op >> 16))
+		}
+		p.set(byte(op), p.alu((op>>25)&0xF, a, b, byte(op)))
+	case 1:
+		switch (op >> 25) & 0xF {
+		case 0, 1: // JMP, JAL
+			var t uint32
+			if op&(1<<24) != 0 {
+				t = (op >> 8) & 0xFFFF
+			} else {
+				t = p.get(byte(op >> 16))
+			}
+			if (op>>25)&0xF == 1 {
+				p.set(byte(op), next)
+			}
+			next = t
+		case 2: // LDI
+			p.set(byte(op), (op>>8)&0xFFFF)
+		case 5: // HALT
+			p.Halted = true
+			next = p.PC
+		case 15: // SLP
+			p.Halted = true
+			next = p.PC
+		default:
+			return p.illegal(op)
+		}
+	case 2, 3: // Quick branch
+		a := p.get(byte(op >> 8))
+		b := p.op2(op)
+		cond := (op >> 27) & 7
+		if (cond&1 != 0 && b > a) || (cond&2 != 0 && b == a) || (cond&4 != 0 && b < a) {
+			next = p.branch(op)
+		}
+	case 6: // Quick branch on bit test
+		v := p.get(byte(op >> 8))
+		bit := v&(1<<(p.op2(op)&31)) != 0
+		switch (op >> 27) & 3 {
+		case 1:
+			if !bit {
+				next = p.branch(op)
+			}
+		case 2:
+			if bit {
+				next = p.branch(op)
+			}
+		default:
+			return p.illegal(op)
+		}
+	case 4, 7: // LBCO/SBCO, LBBO/SBBO
+		var addr uint32
+		if op>>29 == 7 {
+			addr = p.get(0xE0|byte(op>>8)&0x1F) + p.op2(op)
+		} else {
+			addr = p.Const[(op>>8)&0x1F] + p.op2(op)
+		}
+		n := p.burst(op)
+		if err := p.transfer(op, addr, n); err != nil {
+			return err
+		}
+		if op&(1<<28) != 0 {
+			cycles = p.Timing.Load
+		} else {
+			cycles = p.Timing.Store
+		}
+		cycles += (n - 1) / 4
+	default:
+		return p.illegal(op)
+	}
+	p.PC = next
+	p.Cycle += uint64(cycles)
+	return nil
+}
+
+func (p *PRU) illegal(op uint32) error {
+	return fmt.Errorf("PC %d: unsupported instruction 0x%08x", p.PC, op)
+}
+
+// op2 returns the second operand of the instruction, which is either
+// an 8 bit immediate value or a register.
+func (p *PRU) op2(op uint32) uint32 {
+	if op&(1<<24) != 0 {
+		return (op >> 16) & 0xFF
+	}
+	return p.get(byte(op >> 16))
+}
+
+// branch returns the target of a quick branch.
+func (p *PRU) branch(op uint32) uint32 {
+	off := (op>>17)&0x300 | op&0xFF
+	if off&0x200 != 0 {
+		off |= ^uint32(0x3FF)
+	}
+	return p.PC + off
+}
+
+// burst returns the number of bytes in a memory transfer.
+func (p *PRU) burst(op uint32) int {
+	l := (op>>21)&0x70 | (op>>12)&0xE | (op>>7)&1
+	if l >= 124 {
+		return int(p.get(byte(l - 124)))
+	}
+	return int(l) + 1
+}
+
+// transfer copies bytes between the register file and RAM or the IEP registers.
+func (p *PRU) transfer(op, addr uint32, n int) error {
+	mem := p.Ram
+	if addr >= IEPBase && int(addr)+n <= IEPBase+IEPSize {
+		mem = p.iepRead()
+		addr -= IEPBase
+	} else if int(addr)+n > len(p.Ram) {
+		return fmt.Errorf("PC %d: RAM access out of range (address 0x%x, %d bytes)", p.PC, addr, n)
+	}
+	r := int(op&0x1F)*4 + int(op>>5)&3
+	if r+n > 32*4 {
+		return fmt.Errorf("PC %d: register range exceeded", p.PC)
+	}
+	var regs [32 * 4]byte
+	for i, v := range p.Reg {
+		binary.LittleEndian.PutUint32(regs[i*4:], v)
+	}
+	if op&(1<<28) != 0 {
+		copy(regs[r:r+n], mem[addr:])
+		for i := r / 4; i <= (r+n-1)/4; i++ {
+			p.write(i, binary.LittleEndian.Uint32(regs[i*4:]))
+		}
+	} else {
+		if r < 31*4 && r+n > 31*4 {
+			p.Reg[31] = p.input()
+			binary.LittleEndian.PutUint32(regs[31*4:], p.Reg[31])
+		}
+		copy(mem[addr:], regs[r:r+n])
+		if len(mem) == IEPSize {
+			p.iepWrite(mem, addr, n)
+		}
+	}
+	return nil
+}
+
+// iep returns the current value of the IEP counter. When enabled,
+// the counter is incremented on each cycle by the default increment.
+func (p *PRU) iep() uint32 {
+	if p.iepCfg&1 == 0 {
+		return p.iepCount
+	}
+	return p.iepCount + uint32(p.Cycle-p.iepCycle)*(p.iepCfg>>4&0xF)
+}
+
+// iepRead returns a copy of the IEP registers.
+func (p *PRU) iepRead() []byte {
+	regs := make([]byte, IEPSize)
+	binary.LittleEndian.PutUint32(regs[IEPCfg:], p.iepCfg)
+	binary.LittleEndian.PutUint32(regs[IEPCount:], p.iep())
+	return regs
+}
+
+// iepWrite updates the IEP from the n bytes written at addr. Writing
+// to the counter clears the bits that are set.
+func (p *PRU) iepWrite(regs []byte, addr uint32, n int) {
+	written := func(r uint32) bool {
+		return addr < r+4 && addr+uint32(n) > r
+	}
+	count := p.iep()
+	if written(IEPCount) {
+		count &^= binary.LittleEndian.Uint32(regs[IEPCount:])
+	}
+	p.iepCount, p.iepCycle = count, p.Cycle
+	if written(IEPCfg) {
+		p.iepCfg = binary.LittleEndian.Uint32(regs[IEPCfg:])
+	}
+}
+
+// alu performs the arithmetic or logical operation.
+func (p *PRU) alu(f, a, b uint32, dst byte) uint32 {
+	var c uint64
+	if p.Carry {
+		c = 1
+	}
+	mask := uint64(fieldMask(dst))
+	switch f {
+	case 0: // ADD
+		r := uint64(a) + uint64(b)
+		p.Carry = r > mask
+		return uint32(r)
+	case 1: // ADC
+		r := uint64(a) + uint64(b) + c
+		p.Carry = r > mask
+		return uint32(r)
+	case 2: // SUB
+		p.Carry = uint64(b) > uint64(a)
+		return a - b
+	case 3: // SUC
+		p.Carry = uint64(b)+c > uint64(a)
+		return a - b - uint32(c)
+	case 4: // LSL
+		return a << (b & 31)
+	case 5: // LSR
+		return a >> (b & 31)
+	case 6: // RSB
+		p.Carry = uint64(a) > uint64(b)
+		return b - a
+	case 7: // RSC
+		p.Carry = uint64(a)+c > uint64(b)
+		return b - a - uint32(c)
+	case 8: // AND
+		return a & b
+	case 9: // OR
+		return a | b
+	case 10: // XOR
+		return a ^ b
+	case 11: // NOT
+		return ^a
+	case 12: // MIN
+		if b < a {
+			return b
+		}
+		return a
+	case 13: // MAX
+		if b > a {
+			return b
+		}
+		return a
+	case 14: // CLR
+		return a &^ (1 << (b & 31))
+	}
+	// SET
+	return a | (1 << (b & 31))
+}
+
+// fieldMask returns the mask for the register field selector.
+func fieldMask(sel byte) uint32 {
+	switch s := sel >> 5; {
+	case s < 4:
+		return 0xFF
+	case s < 7:
+		return 0xFFFF
+	}
+	return 0xFFFFFFFF
+}
+
+// fieldShift returns the bit offset for the register field selector.
+func fieldShift(sel byte) uint {
+	switch s := sel >> 5; {
+	case s < 4:
+		return uint(s) * 8
+	case s < 7:
+		return uint(s-4) * 8
+	}
+	return 0
+}
+
+// input reads the r31 input bits.
+func (p *PRU) input() uint32 {
+	if p.Input == nil {
+		return 0
+	}
+	return p.Input(p.Cycle)
+}
+
+// get reads a register field.
+func (p *PRU) get(r byte) uint32 {
+	v := p.Reg[r&0x1F]
+	if r&0x1F == 31 {
+		v = p.input()
+	}
+	return (v >> fieldShift(r)) & fieldMask(r)
+}
+
+// set writes a register field.
+func (p *PRU) set(r byte, v uint32) {
+	m := fieldMask(r) << fieldShift(r)
+	n := int(r & 0x1F)
+	p.write(n, p.Reg[n]&^m|(v<<fieldShift(r))&m)
+}
+
+// SetOutput sets the r30 output register, as when the
+// outputs are driven by the host rather than the program.
+func (p *PRU) SetOutput(v uint32) {
+	p.write(30, v)
+}
+
+// write stores a register, handling the output and event registers.
+func (p *PRU) write(n int, v uint32) {
+	switch n {
+	case 30:
+		if v != p.Reg[30] {
+			p.Edges = append(p.Edges, Edge{p.Cycle, v})
+		}
+	case 31:
+		// Bit 5 strobes the event in the low 4 bits.
+		if v&0x20 != 0 && p.Event != nil {
+			p.Event(int(v&0xF) + 16)
+		}
+		return
+	}
+	p.Reg[n] = v
+}
+
+// Signal returns an input function that drives a bit of r31 with a
+// signal starting at level, and changing after each of the timings (in cycles).
+// The level does not change after the last timing.
+func Signal(bit uint, level int, timings []uint64) func(uint64) uint32 {
+	edges := make([]uint64, len(timings))
+	var t uint64
+	for i, v := range timings {
+		t += v
+		edges[i] = t
+	}
+	return func(cycle uint64) uint32 {
+		// Count the edges at or before the cycle.
+		n := sort.Search(len(edges), func(i int) bool { return edges[i] > cycle })
+		if (level+n)%2 != 0 {
+			return 1 << bit
+		}
+		return 0
+	}
+}
+
+// Output returns the timings (in cycles) between changes of a bit of r30,
+// starting from cycle 0 at the level of the bit when the unit was reset,
+// and ending at the current cycle.
+func (p *PRU) Output(bit uint) []uint64 {
+	var tm []uint64
+	var last uint64
+	level := (p.Start >> bit) & 1
+	for _, e := range p.Edges {
+		if b := (e.Value >> bit) & 1; b != level {
+			tm = append(tm, e.Cycle-last)
+			last = e.Cycle
+			level = b
+		}
+	}
+	return append(tm, p.Cycle-last)
+}
+
+// Duration converts cycles to a time.
+func Duration(cycles uint64) time.Duration {
+	return time.Duration(cycles) * time.Second / ClockRate
+}
+
+// MicroSeconds2Ticks converts microseconds to cycles.
+func MicroSeconds2Ticks(us int) int {
+	return us * (ClockRate / 1000000)
+}
@@ -0,0 +1,116 @@
+package emu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/asm"
+)
+
+func run(t *testing.T, src string) *PRU {
+	t.Helper()
+	prog, err := asm.Assemble("test.p", src)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	p := New()
+	p.Load(prog.Code)
+	if err := p.Run(1000000); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !p.Halted {
+		t.Fatalf("program did not halt")
+	}
+	return p
+}
+
+// TestCycles checks the cycle counts of the instructions.
+func TestCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		src    string
+		cycles uint64
+	}{
+		{"halt", "HALT", 1},
+		{"alu", "MOV r1, 5\nADD r1, r1, 1\nHALT", 3},
+		// 2 cycles for each iteration of the delay loop.
+		{"loop", "MOV r1, 10\nLoop:\nSUB r1, r1, 2\nQBNE Loop, r1, 0\nHALT", 12},
+		{"load", "MOV r1, 0\nLBBO r2, r1, 0, 4\nHALT", 5},
+		{"load burst", "MOV r1, 0\nLBBO r2, r1, 0, 12\nHALT", 7},
+		{"store", "MOV r1, 0\nSBBO r2, r1, 0, 4\nHALT", 4},
+		{"store burst", "MOV r1, 0\nSBBO r2, r1, 0, 8\nHALT", 5},
+	} {
+		if c := run(t, ".origin 0\n"+tc.src).Cycle; c != tc.cycles {
+			t.Errorf("%s: %d cycles, expected %d", tc.name, c, tc.cycles)
+		}
+	}
+}
+
+// TestIEP checks that the IEP counter counts cycles once enabled,
+// and is cleared by writing ones to the counter.
+func TestIEP(t *testing.T) {
+	p := run(t, `.origin 0
+    MOV r1, 0x11
+    SBCO r1, c26, 0, 4 ; Enable, increment 1
+    LBCO r2, c26, 0x0C, 4
+    MOV r4, 20
+Loop:
+    SUB r4, r4, 2
+    QBNE Loop, r4, 0
+    LBCO r3, c26, 0x0C, 4
+    MOV r5, 0xFFFFFFFF
+    SBCO r5, c26, 0x0C, 4
+    LBCO r6, c26, 0x0C, 4
+    HALT`)
+	// The second read is 3 (LBCO) + 1 (MOV) + 20 (loop) cycles after the first.
+	if d := p.Reg[3] - p.Reg[2]; d != 24 {
+		t.Errorf("counter advanced %d, expected 24", d)
+	}
+	// Cleared by the store, and read 2 cycles later.
+	if p.Reg[6] != 2 {
+		t.Errorf("counter %d after clearing, expected 2", p.Reg[6])
+	}
+}
+
+// TestSignal checks that the input follows the timings, and that
+// the output timings are measured in cycles.
+func TestSignal(t *testing.T) {
+	in := Signal(3, 1, []uint64{10, 20, 5})
+	for _, tc := range []struct {
+		cycle uint64
+		want  uint32
+	}{
+		{0, 8}, {9, 8}, {10, 0}, {29, 0}, {30, 8}, {34, 8}, {35, 0}, {1000, 0},
+	} {
+		if v := in(tc.cycle); v != tc.want {
+			t.Errorf("input at cycle %d is %#x, expected %#x", tc.cycle, v, tc.want)
+		}
+	}
+	p := run(t, `.origin 0
+    SET r30, r30, 2
+    MOV r1, 10
+Loop:
+    SUB r1, r1, 2
+    QBNE Loop, r1, 0
+    CLR r30, r30, 2
+    SET r30, r30, 5
+    HALT`)
+	// The output changes at the start of the cycle, so it is high from
+	// the first cycle for the SET, MOV and loop, then low until the end.
+	if got, want := p.Output(2), []uint64{0, 12, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("output %v, expected %v", got, want)
+	}
+}
+
+func TestConversion(t *testing.T) {
+	if d := Duration(ClockRate); d != time.Second {
+		t.Errorf("%d cycles is %s, expected 1s", ClockRate, d)
+	}
+	if d := Duration(3); d != 15*time.Nanosecond {
+		t.Errorf("3 cycles is %s, expected 15ns", d)
+	}
+	if c := MicroSeconds2Ticks(250); c != 50000 {
+		t.Errorf("250us is %d cycles, expected 50000", c)
+	}
+}
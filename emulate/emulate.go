@@ -0,0 +1,129 @@
+// Program to run the PRU firmware on an emulated PRU and check the timing.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/rf/emu"
+	"github.com/aamcrae/rf/io"
+	"github.com/aamcrae/rf/message"
+)
+
+var file = flag.String("file", "", "Message file database")
+var msg = flag.String("message", "", "Message name")
+var timings = flag.String("timings", "", "Comma separated message timings (microseconds)")
+var repeats = flag.Int("repeat", 1, "Number of repeats")
+var gap = flag.Int("gap", 5000, "Inter-message gap (microseconds)")
+var txGpio = flag.Int("txgpio", 15, "Transmitter output GPIO number")
+var rxGpio = flag.Int("rxgpio", 5, "Receiver input GPIO number")
+var rxFirmware = flag.String("rxfirmware", "", "Optional receiver firmware file")
+var txFirmware = flag.String("txfirmware", "", "Optional transmitter firmware file")
+var activeLow = flag.Bool("active-low", false, "Transmitter output is low while transmitting")
+var idleHigh = flag.Bool("idle-high", false, "Leave the transmitter output high between transmissions")
+var verbose = flag.Bool("v", false, "Log more information")
+
+func main() {
+	flag.Parse()
+	m, err := getMessage()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	tx := io.NewEmuTransmitter(uint(*txGpio))
+	tx.Gap = *gap
+	tx.SetPolarity(io.Polarity{ActiveLow: *activeLow, IdleHigh: *idleHigh})
+	if len(*txFirmware) > 0 {
+		if err := io.LoadFirmware(tx, *txFirmware); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if err := tx.Send(m, *repeats); err != nil {
+		log.Fatalf("Transmit: %v", err)
+	}
+	out := tx.Signal()
+	var want []uint64
+	for _, t := range io.Expand(m, *repeats, *gap) {
+		want = append(want, uint64(emu.MicroSeconds2Ticks(t)))
+	}
+	fmt.Printf("Transmitter: %d periods, took %s\n", len(out), tx.PRU.Time())
+	compare(want, out)
+
+	// Loop the transmitted signal into the receiver.
+	var in []time.Duration
+	for _, t := range out {
+		in = append(in, emu.Duration(t))
+	}
+	rx := io.NewEmuReceiver(uint(*rxGpio), in)
+	if len(*rxFirmware) > 0 {
+		if err := io.LoadFirmware(rx, *rxFirmware); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	got, err := io.Capture(rx, len(out))
+	if err != nil {
+		log.Fatalf("Receive: %v", err)
+	}
+	var meas []uint64
+	for _, d := range got {
+		meas = append(meas, uint64(d*emu.ClockRate/time.Second))
+	}
+	fmt.Printf("Receiver: %d periods\n", len(meas))
+	compare(out, meas)
+}
+
+// compare prints the differences in cycles between the expected and actual timings.
+func compare(want, got []uint64) {
+	n := len(want)
+	if len(got) < n {
+		n = len(got)
+	}
+	var min, max, total [2]int64
+	for i := 0; i < n; i++ {
+		d := int64(got[i]) - int64(want[i])
+		level := i % 2
+		if i < 2 || d < min[level] {
+			min[level] = d
+		}
+		if i < 2 || d > max[level] {
+			max[level] = d
+		}
+		total[level] += d
+		if *verbose {
+			fmt.Printf("%4d: level %d, expected %8d, actual %8d, error %4d\n", i, level, want[i], got[i], d)
+		}
+	}
+	for level, name := range []string{"low", "high"} {
+		c := int64((n + 1 - level) / 2)
+		if c == 0 {
+			continue
+		}
+		fmt.Printf("  %-4s error (cycles): min %d, max %d, average %.1f\n", name, min[level], max[level], float64(total[level])/float64(c))
+	}
+}
+
+func getMessage() (message.Raw, error) {
+	if len(*timings) > 0 {
+		var m message.Raw
+		for _, s := range strings.Split(*timings, ",") {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad timing: %v", s, err)
+			}
+			m = append(m, v)
+		}
+		return m, nil
+	}
+	msgs, err := message.ReadTagFile(*file)
+	if err != nil {
+		return nil, err
+	}
+	ml, ok := msgs[*msg]
+	if !ok {
+		return nil, fmt.Errorf("%s: message not found", *msg)
+	}
+	return ml[0], nil
+}
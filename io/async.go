@@ -0,0 +1,121 @@
+// Sending messages without blocking the caller.
+
+package io
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the outcome of a transmission.
+type Result struct {
+	Duration time.Duration // Time taken to send the signal
+	Err      error
+}
+
+// Transmission is a message being sent asynchronously.
+type Transmission struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	res    Result
+}
+
+// AsyncSink is a Sink that can send messages without blocking the caller,
+// and abort a transmission in progress.
+type AsyncSink interface {
+	Sink
+	SendAsync(ctx context.Context, msg []time.Duration, repeats int) *Transmission
+	SendSequenceAsync(ctx context.Context, seq []Entry) *Transmission
+}
+
+// Done returns a channel that is closed once the transmission has completed.
+func (t *Transmission) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits for the transmission to complete, and returns the result.
+func (t *Transmission) Wait() Result {
+	<-t.done
+	return t.res
+}
+
+// Cancel aborts the transmission. If the message is being sent,
+// the transmitter is stopped and the output is set to the idle level.
+func (t *Transmission) Cancel() {
+	t.cancel()
+}
+
+// SendAsync sends a message without blocking. If the Sink does not support
+// asynchronous sending, the message is sent from a separate goroutine,
+// and cannot be aborted once it has started.
+func SendAsync(ctx context.Context, s Sink, msg []time.Duration, repeats int) *Transmission {
+	if a, ok := s.(AsyncSink); ok {
+		return a.SendAsync(ctx, msg, repeats)
+	}
+	return startAsync(ctx, func(ctx context.Context) (time.Duration, error) {
+		return timed(ctx, func() error {
+			return SendPrecise(s, msg, repeats)
+		})
+	})
+}
+
+// SendSequenceAsync sends a sequence of messages without blocking,
+// in the same way as SendAsync.
+func SendSequenceAsync(ctx context.Context, s Sink, seq []Entry) *Transmission {
+	if a, ok := s.(AsyncSink); ok {
+		return a.SendSequenceAsync(ctx, seq)
+	}
+	return startAsync(ctx, func(ctx context.Context) (time.Duration, error) {
+		return timed(ctx, func() error {
+			return SendSequence(s, seq)
+		})
+	})
+}
+
+// startAsync runs send in a new goroutine, returning the Transmission
+// that reports the result.
+func startAsync(ctx context.Context, send func(ctx context.Context) (time.Duration, error)) *Transmission {
+	t, ctx := newTransmission(ctx)
+	go func() {
+		t.finish(send(ctx))
+	}()
+	return t
+}
+
+// newTransmission returns a Transmission, and the context
+// that is done when it is cancelled.
+func newTransmission(ctx context.Context) (*Transmission, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Transmission{done: make(chan struct{}), cancel: cancel}, ctx
+}
+
+// finish records the result and marks the transmission as completed.
+func (t *Transmission) finish(d time.Duration, err error) {
+	t.res = Result{Duration: d, Err: err}
+	t.cancel()
+	close(t.done)
+}
+
+// startTransmit sends a signal asynchronously on the gpio output of a transmitter.
+func startTransmit(ctx context.Context, tx transmitter, gpio uint32, signal []time.Duration, repeats int) *Transmission {
+	return startAsync(ctx, func(ctx context.Context) (time.Duration, error) {
+		return tx.transmit(ctx, gpio, signal, repeats, Carrier{})
+	})
+}
+
+// startSequence sends a sequence of messages asynchronously on the gpio output of a transmitter.
+func startSequence(ctx context.Context, tx transmitter, gpio uint32, seq []Entry) *Transmission {
+	return startAsync(ctx, func(ctx context.Context) (time.Duration, error) {
+		return sendSequence(ctx, tx, gpio, seq)
+	})
+}
+
+// timed calls send unless the context is already done, returning the time taken.
+func timed(ctx context.Context, send func() error) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	err := send()
+	return time.Since(start), err
+}
@@ -0,0 +1,46 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCancel cancels a transmission part way through, and checks that
+// the transmission is aborted, and the output is left at the idle level.
+func TestCancel(t *testing.T) {
+	const gpio = 4
+	msg := seqMsg(401)
+	var full time.Duration
+	for _, d := range txMessage(msg, defaultGap) {
+		full += d
+	}
+	for _, pol := range []Polarity{{}, {ActiveLow: true}, {IdleHigh: true}, {ActiveLow: true, IdleHigh: true}} {
+		tx := NewEmuTransmitter(gpio)
+		tx.SetPolarity(pol)
+		tr := tx.SendAsync(context.Background(), msg, 1)
+		time.Sleep(20 * time.Millisecond)
+		tr.Cancel()
+		res := tr.Wait()
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("%+v: cancelled transmission returned %v, expected %v", pol, res.Err, context.Canceled)
+		}
+		if res.Duration <= 0 || res.Duration >= full {
+			t.Errorf("%+v: cancelled transmission took %s, expected less than %s", pol, res.Duration, full)
+		}
+		if l := tx.PRU.Reg[30] >> gpio & 1; l != pol.idle() {
+			t.Errorf("%+v: output %d after cancelling, expected %d", pol, l, pol.idle())
+		}
+		if st := tx.Stats(); st.Aborted != 1 || st.Sent != 0 {
+			t.Errorf("%+v: %d aborted, %d sent, expected 1, 0", pol, st.Aborted, st.Sent)
+		}
+	}
+	// A transmission cancelled before it starts is not sent.
+	tx := NewEmuTransmitter(gpio)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := tx.SendAsync(ctx, msg, 1).Wait(); !errors.Is(res.Err, context.Canceled) || res.Duration != 0 {
+		t.Errorf("transmission cancelled before starting returned %v after %s", res.Err, res.Duration)
+	}
+}
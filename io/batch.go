@@ -0,0 +1,139 @@
+// Batch oriented receiving of timings.
+
+package io
+
+import (
+	"context"
+	"time"
+)
+
+// BatchSource is a Source that can also deliver timings in blocks,
+// avoiding a channel operation for each timing.
+// Blocks may be returned for reuse by calling Release.
+type BatchSource interface {
+	Source
+	StartBatch() (<-chan []time.Duration, error)
+	Release(b []time.Duration)
+}
+
+// Iterator reads timings from a Source until the Source is stopped
+// or the context is cancelled. Blocks are used if the Source supports them.
+type Iterator struct {
+	ctx   context.Context
+	src   Source
+	batch BatchSource
+	bc    <-chan []time.Duration
+	c     <-chan time.Duration
+	cur   []time.Duration
+	pos   int
+}
+
+// bufPool is a pool of timing blocks.
+type bufPool struct {
+	size int
+	free chan []time.Duration
+}
+
+// Iterate starts the source and returns an Iterator for the timings.
+func Iterate(ctx context.Context, s Source) (*Iterator, error) {
+	it := &Iterator{ctx: ctx, src: s}
+	var err error
+	if b, ok := s.(BatchSource); ok {
+		it.batch = b
+		it.bc, err = b.StartBatch()
+	} else {
+		it.c, err = s.Start()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// Next returns the next timing, or false if the source has
+// finished or the context has been cancelled.
+func (it *Iterator) Next() (time.Duration, bool) {
+	if it.batch == nil {
+		select {
+		case d, ok := <-it.c:
+			return d, ok
+		case <-it.ctx.Done():
+			return 0, false
+		}
+	}
+	for it.pos >= len(it.cur) {
+		if it.cur != nil {
+			it.batch.Release(it.cur)
+			it.cur = nil
+		}
+		select {
+		case b, ok := <-it.bc:
+			if !ok {
+				return 0, false
+			}
+			it.cur, it.pos = b, 0
+		case <-it.ctx.Done():
+			return 0, false
+		}
+	}
+	d := it.cur[it.pos]
+	it.pos++
+	return d, true
+}
+
+// Err returns the context error if the iterator was cancelled.
+func (it *Iterator) Err() error {
+	return it.ctx.Err()
+}
+
+// Stop the source.
+func (it *Iterator) Stop() {
+	// Discard remaining blocks so the source is not blocked.
+	if it.batch != nil {
+		go func(c <-chan []time.Duration) {
+			for range c {
+			}
+		}(it.bc)
+	} else {
+		go func(c <-chan time.Duration) {
+			for range c {
+			}
+		}(it.c)
+	}
+	it.src.Stop()
+}
+
+func newBufPool(count, size int) *bufPool {
+	return &bufPool{size: size, free: make(chan []time.Duration, count)}
+}
+
+// get returns an empty block, with room for a Dropped marker.
+func (p *bufPool) get() []time.Duration {
+	select {
+	case b := <-p.free:
+		return b[:0]
+	default:
+		return make([]time.Duration, 0, p.size+1)
+	}
+}
+
+func (p *bufPool) put(b []time.Duration) {
+	if cap(b) < p.size+1 {
+		return
+	}
+	select {
+	case p.free <- b:
+	default:
+	}
+}
+
+// unbatch sends the timings from each block individually.
+func unbatch(in <-chan []time.Duration, send chan<- time.Duration, release func([]time.Duration)) {
+	for b := range in {
+		for _, d := range b {
+			send <- d
+		}
+		release(b)
+	}
+	close(send)
+}
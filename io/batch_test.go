@@ -0,0 +1,71 @@
+package io
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const benchTimings = 10000 // Number of timings received in each run
+
+var benchData = func() []time.Duration {
+	timings := make([]time.Duration, benchTimings)
+	for i := range timings {
+		timings[i] = time.Duration(300+i%700) * time.Microsecond
+	}
+	return timings
+}()
+
+func benchSource() *MemorySource {
+	s := NewMemorySource(benchData)
+	s.BatchSize = rxBufferSize
+	return s
+}
+
+// reportPerTiming reports the time taken for each timing received.
+func reportPerTiming(b *testing.B) {
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/benchTimings, "ns/timing")
+}
+
+func BenchmarkChannel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c, err := benchSource().Start()
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+		for range c {
+		}
+	}
+	reportPerTiming(b)
+}
+
+func BenchmarkBatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s := benchSource()
+		c, err := s.StartBatch()
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+		for blk := range c {
+			for range blk {
+			}
+			s.Release(blk)
+		}
+	}
+	reportPerTiming(b)
+}
+
+func BenchmarkIterator(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		it, err := Iterate(context.Background(), benchSource())
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+		for {
+			if _, ok := it.Next(); !ok {
+				break
+			}
+		}
+	}
+	reportPerTiming(b)
+}
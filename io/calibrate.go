@@ -0,0 +1,187 @@
+// Compensation of the timing errors of a transmitter and receiver.
+
+package io
+
+import (
+	"bufio"
+	"fmt"
+	stdio "io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const calTolerance = 50                     // Percent tolerance when finding the received pattern
+const calSettle = 100 * time.Millisecond    // Time allowed for the receiver to deliver the last timings
+const calMinPeriod = 2 * time.Microsecond   // Shortest period left after correcting a timing
+const calHeader = "# rf timing calibration" // First line of a calibration file
+
+// CalibrationPattern is the message sent by Calibrate, with each high
+// period followed by each low period so that the errors of both levels
+// are measured across a range of lengths.
+var CalibrationPattern = calPattern([]int{100, 200, 400, 800, 1600})
+
+// Calibration is the timing compensation for a transmitter and receiver,
+// measured by sending known patterns into a receiver looped back to the
+// transmitter. Messages captured with the receiver are distorted in the
+// same way, so the errors are removed before the messages are sent.
+type Calibration struct {
+	Offset time.Duration // Error common to every period, such as firmware overhead
+	Bias   time.Duration // Error added to the high periods and removed from the low periods
+}
+
+// calPattern returns a pattern starting and ending with a high period,
+// containing each combination of the high and low lengths (in microseconds).
+func calPattern(lengths []int) []time.Duration {
+	var p []time.Duration
+	for _, h := range lengths {
+		for _, l := range lengths {
+			p = append(p, time.Duration(h)*time.Microsecond, time.Duration(l)*time.Microsecond)
+		}
+	}
+	return append(p, time.Duration(lengths[0])*time.Microsecond)
+}
+
+// Calibrate sends the pattern repeats times on the transmitter, and measures
+// the errors of the timings seen by the receiver, which must be connected to
+// the transmitter output.
+func Calibrate(tx Sink, rx Source, pattern []time.Duration, repeats int) (Calibration, error) {
+	var c Calibration
+	ch, err := rx.Start()
+	if err != nil {
+		return c, err
+	}
+	done := make(chan []time.Duration)
+	go func() {
+		var tm []time.Duration
+		for d := range ch {
+			tm = append(tm, d)
+		}
+		done <- tm
+	}()
+	err = SendPrecise(tx, pattern, repeats)
+	time.Sleep(calSettle)
+	rx.Stop()
+	received := <-done
+	if err != nil {
+		return c, err
+	}
+	var errs [2]time.Duration
+	var count [2]int
+	matched := 0
+	// The first received timing is low, so high periods are at odd indexes.
+	for i := 1; i+len(pattern) <= len(received); i += 2 {
+		if !calMatch(received[i:i+len(pattern)], pattern) {
+			continue
+		}
+		for j, t := range pattern {
+			level := (j + 1) % 2
+			errs[level] += received[i+j] - t
+			count[level]++
+		}
+		matched++
+		i += len(pattern) - 1
+	}
+	if matched == 0 {
+		return c, fmt.Errorf("calibration pattern not received (%d timings), check the loopback", len(received))
+	}
+	high := errs[1] / time.Duration(count[1])
+	low := errs[0] / time.Duration(count[0])
+	c.Offset = (high + low) / 2
+	c.Bias = (high - low) / 2
+	return c, nil
+}
+
+// calMatch returns true if the received timings are within tolerance of the pattern.
+func calMatch(received, pattern []time.Duration) bool {
+	for i, t := range pattern {
+		d := received[i] - t
+		if d < 0 {
+			d = -d
+		}
+		if d > t*calTolerance/100 {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the message with the errors removed, so that the message
+// is sent as it was originally received. The error of each level is removed
+// from the periods captured at that level. level is the receiver level
+// (0 low, 1 high) of the first period of the message, and the levels alternate
+// after it. Messages captured by a message.Listener start at the level of
+// the gap between messages, since the period after the gap is not kept.
+func (c Calibration) Apply(msg []time.Duration, level int) []time.Duration {
+	out := make([]time.Duration, len(msg))
+	for i, t := range msg {
+		if (level+i)%2 != 0 {
+			t -= c.Offset + c.Bias
+		} else {
+			t -= c.Offset - c.Bias
+		}
+		if t < calMinPeriod {
+			t = calMinPeriod
+		}
+		out[i] = t
+	}
+	return out
+}
+
+func (c Calibration) String() string {
+	return fmt.Sprintf("offset %s, bias %s", c.Offset, c.Bias)
+}
+
+// ReadCalibration reads a calibration file written by Calibration.Write.
+// The file has a header line, followed by lines of the form:
+//
+//	offset <microseconds>
+//	bias <microseconds>
+func ReadCalibration(name string) (Calibration, error) {
+	var c Calibration
+	f, err := os.Open(name)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	if !scan.Scan() || scan.Text() != calHeader {
+		if err := scan.Err(); err != nil {
+			return c, err
+		}
+		return c, fmt.Errorf("%s: not a calibration file", name)
+	}
+	lineno := 1
+	for scan.Scan() {
+		lineno++
+		line := strings.TrimSpace(scan.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, val, ok := strings.Cut(line, " ")
+		if !ok {
+			return c, fmt.Errorf("%s: line %d: unknown format", name, lineno)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return c, fmt.Errorf("%s: line %d: bad value %s", name, lineno, val)
+		}
+		d := time.Duration(v * float64(time.Microsecond))
+		switch key {
+		case "offset":
+			c.Offset = d
+		case "bias":
+			c.Bias = d
+		default:
+			return c, fmt.Errorf("%s: line %d: unknown setting %s", name, lineno, key)
+		}
+	}
+	return c, scan.Err()
+}
+
+// Write the calibration in the format read by ReadCalibration.
+func (c Calibration) Write(w stdio.Writer) error {
+	_, err := fmt.Fprintf(w, "%s\noffset %.3f\nbias %.3f\n", calHeader, c.Offset.Seconds()*1e6, c.Bias.Seconds()*1e6)
+	return err
+}
@@ -0,0 +1,55 @@
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalibrationApply(t *testing.T) {
+	c := Calibration{Offset: 10 * time.Microsecond, Bias: 5 * time.Microsecond}
+	msg := []time.Duration{500 * time.Microsecond, 1000 * time.Microsecond, 500 * time.Microsecond}
+	for _, tc := range []struct {
+		level int
+		want  []time.Duration
+	}{
+		{0, []time.Duration{495 * time.Microsecond, 985 * time.Microsecond, 495 * time.Microsecond}},
+		{1, []time.Duration{485 * time.Microsecond, 995 * time.Microsecond, 485 * time.Microsecond}},
+	} {
+		got := c.Apply(msg, tc.level)
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("level %d: period %d is %s, expected %s", tc.level, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestReadCalibration(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "cal")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := Calibration{Offset: 12500 * time.Nanosecond, Bias: -3 * time.Microsecond}
+	if err := c.Write(f); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.Close()
+	got, err := ReadCalibration(name)
+	if err != nil {
+		t.Fatalf("ReadCalibration: %v", err)
+	}
+	if got != c {
+		t.Errorf("read %s, expected %s", got, c)
+	}
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(bad, []byte("offset 1\nbias 2\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := ReadCalibration(bad); err == nil {
+		t.Errorf("file without a header read without error")
+	}
+}
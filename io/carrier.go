@@ -0,0 +1,104 @@
+// Carrier modulation of transmitted messages, as used by infrared LEDs.
+
+package io
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDuty = 33     // Default carrier duty cycle (percent)
+const txCarrierAdjust = 12 // Extra ticks taken after sending carrier cycles (13, less one to keep the delay even)
+
+// Carrier is the modulation of the periods that the output is on.
+// A Freq of 0 is no carrier, with the output held on.
+type Carrier struct {
+	Freq int // Carrier frequency in Hz
+	Duty int // Percentage of each carrier cycle that the output is on
+}
+
+// ParseCarrier parses a carrier frequency in Hz or kHz, with an optional
+// duty cycle in percent, e.g. 38kHz, 36000Hz/25%. An empty string is no carrier.
+func ParseCarrier(s string) (Carrier, error) {
+	var c Carrier
+	if len(s) == 0 {
+		return c, nil
+	}
+	f, d, ok := strings.Cut(s, "/")
+	c.Duty = defaultDuty
+	if ok {
+		v, err := strconv.Atoi(strings.TrimSuffix(d, "%"))
+		if err != nil || v <= 0 || v >= 100 {
+			return c, fmt.Errorf("%s: bad carrier duty cycle", s)
+		}
+		c.Duty = v
+	}
+	mult := 1.0
+	lf := strings.ToLower(f)
+	switch {
+	case strings.HasSuffix(lf, "khz"):
+		mult = 1000
+		lf = strings.TrimSuffix(lf, "khz")
+	case strings.HasSuffix(lf, "hz"):
+		lf = strings.TrimSuffix(lf, "hz")
+	default:
+		return c, fmt.Errorf("%s: carrier frequency must be in Hz or kHz", s)
+	}
+	v, err := strconv.ParseFloat(lf, 64)
+	if err != nil || v <= 0 {
+		return c, fmt.Errorf("%s: bad carrier frequency", s)
+	}
+	c.Freq = int(v*mult + 0.5)
+	return c, nil
+}
+
+func (c Carrier) String() string {
+	if c.Freq == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%gkHz/%d%%", float64(c.Freq)/1000, c.Duty)
+}
+
+// cycle returns the high and low PRU ticks of each carrier cycle, using
+// ticks to convert microseconds to PRU ticks. The times are even, to
+// suit the 2 tick firmware delay loops.
+func (c Carrier) cycle(ticks func(int) int) (high, low uint32, err error) {
+	perSec := int64(ticks(1000)) * 1000
+	period := 2 * ((perSec/int64(c.Freq) + 1) / 2)
+	h := 2 * ((period*int64(c.Duty)/100 + 1) / 2)
+	// Allow for the instructions in the high and low parts of each cycle.
+	if h < 6 || period-h < 4 {
+		return 0, 0, fmt.Errorf("carrier %s not supported", c)
+	}
+	return uint32(h), uint32(period - h), nil
+}
+
+// carrierTimings converts the signal to firmware timings. While the
+// output is high, the timing is the number of carrier cycles, and the
+// following low timing is adjusted so that the message timing is kept.
+func carrierTimings(signal []time.Duration, conv func(time.Duration) uint32, high, low uint32) []uint32 {
+	period := int64(high + low)
+	tm := make([]uint32, len(signal))
+	var carry int64 // Ticks to add to the next low timing
+	for i, t := range signal {
+		v := int64(conv(t))
+		if i%2 == 0 {
+			v += carry
+			carry = 0
+			if v < 8 {
+				v = 8
+			}
+			tm[i] = uint32(v)
+			continue
+		}
+		n := (v + period/2) / period
+		if n == 0 {
+			n = 1
+		}
+		tm[i] = uint32(n)
+		carry = v - n*period - txCarrierAdjust
+	}
+	return tm
+}
@@ -0,0 +1,97 @@
+package io
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/emu"
+)
+
+// TestCarrier sends a signal modulated by a carrier on the emulated
+// transmitter, and checks the carrier cycles, the number of cycles in
+// each on period, and that the start of each on period is kept.
+func TestCarrier(t *testing.T) {
+	sig := []time.Duration{
+		1000 * time.Microsecond,
+		560 * time.Microsecond,
+		560 * time.Microsecond,
+		1690 * time.Microsecond,
+		560 * time.Microsecond,
+		9000 * time.Microsecond,
+		4500 * time.Microsecond,
+		600 * time.Microsecond,
+		300 * time.Microsecond,
+	}
+	conv := tickConv(emu.MicroSeconds2Ticks)
+	for _, c := range []Carrier{{38000, 50}, {38000, 33}, {36000, 25}, {56000, 33}} {
+		high, low, err := c.cycle(emu.MicroSeconds2Ticks)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		period := uint64(high + low)
+		tx := NewEmuTransmitter(0)
+		if _, err := tx.transmit(context.Background(), tx.gpio, sig, 1, c); err != nil {
+			t.Fatalf("%s: transmit: %v", c, err)
+		}
+		out := tx.Signal()
+		// Split the output into on periods, each a list of carrier cycles.
+		// The first period includes the time taken to start the firmware.
+		var starts []uint64
+		var bursts [][]uint64
+		now := out[0]
+		for i := 1; i < len(out)-1; i += 2 {
+			if i == 1 || out[i-1] > period {
+				starts = append(starts, now-out[0])
+				bursts = append(bursts, nil)
+			}
+			bursts[len(bursts)-1] = append(bursts[len(bursts)-1], out[i], out[i+1])
+			now += out[i] + out[i+1]
+		}
+		if len(bursts) != len(sig)/2 {
+			t.Fatalf("%s: %d on periods, expected %d", c, len(bursts), len(sig)/2)
+		}
+		var want uint64
+		for k, b := range bursts {
+			on := uint64(conv(sig[2*k+1]))
+			if n := uint64(len(b) / 2); n != (on+period/2)/period {
+				t.Errorf("%s: on period %d has %d carrier cycles, expected %d", c, k, n, (on+period/2)/period)
+			}
+			// The low part of the last cycle is part of the off period.
+			for i := 0; i < len(b); i += 2 {
+				if b[i] != uint64(high) || (i+2 < len(b) && b[i+1] != uint64(low)) {
+					t.Errorf("%s: on period %d carrier cycle %d is %d/%d, expected %d/%d", c, k, i/2, b[i], b[i+1], high, low)
+					break
+				}
+			}
+			// Each on and off pair takes one tick longer than the timings,
+			// since the firmware delay loop needs an even adjustment.
+			if starts[k] != want+uint64(k) {
+				t.Errorf("%s: on period %d starts at %d, expected %d", c, k, starts[k], want+uint64(k))
+			}
+			want += on + uint64(conv(sig[2*k+2]))
+		}
+	}
+}
+
+func TestParseCarrier(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Carrier
+	}{
+		{"", Carrier{}},
+		{"38kHz", Carrier{38000, defaultDuty}},
+		{"36000Hz/25%", Carrier{36000, 25}},
+		{"56.5khz/50", Carrier{56500, 50}},
+	} {
+		c, err := ParseCarrier(tc.s)
+		if err != nil || c != tc.want {
+			t.Errorf("%q: got %+v (%v), expected %+v", tc.s, c, err, tc.want)
+		}
+	}
+	for _, s := range []string{"38", "38kHz/0%", "38kHz/100", "xkHz", "0Hz"} {
+		if _, err := ParseCarrier(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Estimating when the timings from a source ended.
+
+package io
+
+import (
+	"time"
+)
+
+// LatencyReporter is implemented by sources that deliver each timing some
+// time after the edge that ends it, such as receivers that deliver
+// timings in blocks.
+type LatencyReporter interface {
+	Latency() time.Duration
+}
+
+// Latency returns the longest time a source takes to deliver a timing
+// after the edge that ends it, or 0 if the source does not report it.
+func Latency(src interface{}) time.Duration {
+	if l, ok := src.(LatencyReporter); ok {
+		return l.Latency()
+	}
+	return 0
+}
+
+// Clock estimates when each timing read from a source ended. Since a source
+// may deliver timings some time after they end, the time a timing is read
+// is not used. Instead, the end of each timing is calculated from the time
+// the source was started, and kept within the latency of the source so
+// that it does not drift from the system clock.
+// A Clock is not safe for concurrent use.
+type Clock struct {
+	latency time.Duration
+	last    time.Time
+}
+
+// NewClock returns a Clock for a source that has just been started.
+func NewClock(src interface{}) *Clock {
+	return &Clock{latency: Latency(src), last: time.Now()}
+}
+
+// Add records a timing read from the source, and returns the time it ended.
+// If timings were lost, the time is restarted from when Dropped was read.
+func (c *Clock) Add(d time.Duration) time.Time {
+	now := time.Now()
+	if d == Dropped {
+		c.last = now
+		return now
+	}
+	c.last = c.last.Add(d)
+	if c.last.After(now) {
+		c.last = now
+	} else if early := now.Add(-c.latency); c.last.Before(early) {
+		c.last = early
+	}
+	return c.last
+}
+
+// Time returns the time that the last timing ended.
+func (c *Clock) Time() time.Time {
+	return c.last
+}
+
+// Idle returns how long the signal is known to have been unchanged
+// since the last timing ended, allowing for the latency of the source.
+func (c *Clock) Idle() time.Duration {
+	d := time.Since(c.last) - c.latency
+	if d < 0 {
+		return 0
+	}
+	return d
+}
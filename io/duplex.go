@@ -0,0 +1,267 @@
+// Half-duplex coordination between a transmitter and receiver.
+
+package io
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const duplexHistory = time.Minute // How long transmissions are remembered
+
+// Duplex tracks when a transmitter is active, so that a receiver in the
+// same process can ignore or identify its own transmissions.
+// A transmission is considered active from when it is started until
+// the guard time after it has completed.
+type Duplex struct {
+	Guard time.Duration
+	lock  sync.Mutex
+	spans []*span
+}
+
+// span is the time a transmission was active. The end is zero while
+// the transmission is in progress.
+type span struct {
+	start, end time.Time
+}
+
+// duplexSink marks the time that messages are being sent.
+type duplexSink struct {
+	Sink
+	d *Duplex
+}
+
+// DuplexSource is a Source that tracks the time of each timing received,
+// and optionally removes the timings received while transmitting.
+type DuplexSource struct {
+	src     Source
+	d       *Duplex
+	gate    bool
+	lock    sync.Mutex
+	last    time.Time
+	latency time.Duration
+	pending bool // Timings are being held back
+	wg      sync.WaitGroup
+}
+
+func NewDuplex(guard time.Duration) *Duplex {
+	return &Duplex{Guard: guard}
+}
+
+// Sink returns a Sink that records when messages are being sent.
+func (d *Duplex) Sink(s Sink) Sink {
+	return &duplexSink{Sink: s, d: d}
+}
+
+// Source returns a Source that tracks when timings are received. If gate is
+// set, timings received while transmitting are merged into a single low period.
+func (d *Duplex) Source(s Source, gate bool) *DuplexSource {
+	return &DuplexSource{src: s, d: d, gate: gate}
+}
+
+// Active returns true if a transmission was active at any time between start and end.
+func (d *Duplex) Active(start, end time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, s := range d.spans {
+		if s.start.After(end) {
+			continue
+		}
+		if s.end.IsZero() || !s.end.Add(d.Guard).Before(start) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Duplex) begin() *span {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	// Discard old transmissions.
+	spans := d.spans[:0]
+	for _, s := range d.spans {
+		if s.end.IsZero() || now.Sub(s.end) < duplexHistory+d.Guard {
+			spans = append(spans, s)
+		}
+	}
+	s := &span{start: now}
+	d.spans = append(spans, s)
+	return s
+}
+
+func (d *Duplex) end(s *span) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	s.end = time.Now()
+}
+
+func (ds *duplexSink) Send(msg []int, repeats int) error {
+	s := ds.d.begin()
+	defer ds.d.end(s)
+	return ds.Sink.Send(msg, repeats)
+}
+
+func (ds *duplexSink) SendPrecise(msg []time.Duration, repeats int) error {
+	s := ds.d.begin()
+	defer ds.d.end(s)
+	return SendPrecise(ds.Sink, msg, repeats)
+}
+
+func (ds *duplexSink) SendSequence(seq []Entry) error {
+	s := ds.d.begin()
+	defer ds.d.end(s)
+	return SendSequence(ds.Sink, seq)
+}
+
+func (ds *duplexSink) SendAsync(ctx context.Context, msg []time.Duration, repeats int) *Transmission {
+	s := ds.d.begin()
+	return ds.track(s, SendAsync(ctx, ds.Sink, msg, repeats))
+}
+
+func (ds *duplexSink) SendSequenceAsync(ctx context.Context, seq []Entry) *Transmission {
+	s := ds.d.begin()
+	return ds.track(s, SendSequenceAsync(ctx, ds.Sink, seq))
+}
+
+func (ds *duplexSink) gap() int {
+	return txGap(ds.Sink)
+}
+
+// track ends the span once the transmission has completed. The span
+// starts when the transmission is requested, since it is not known
+// when the transmitter starts sending.
+func (ds *duplexSink) track(s *span, t *Transmission) *Transmission {
+	go func() {
+		<-t.Done()
+		ds.d.end(s)
+	}()
+	return t
+}
+
+func (ds *DuplexSource) Close() {
+	ds.src.Close()
+}
+
+func (ds *DuplexSource) Start() (<-chan time.Duration, error) {
+	c, err := ds.src.Start()
+	if err != nil {
+		return nil, err
+	}
+	clock := NewClock(ds.src)
+	ds.lock.Lock()
+	ds.last = clock.Time()
+	ds.latency = Latency(ds.src)
+	ds.pending = false
+	ds.lock.Unlock()
+	send := make(chan time.Duration, 200)
+	ds.wg.Add(1)
+	go ds.run(c, send, clock)
+	return send, nil
+}
+
+func (ds *DuplexSource) Stop() {
+	ds.src.Stop()
+	ds.wg.Wait()
+}
+
+// Latency returns the latency of the underlying source.
+func (ds *DuplexSource) Latency() time.Duration {
+	return Latency(ds.src)
+}
+
+// Time returns the time that the last timing delivered ended.
+func (ds *DuplexSource) Time() time.Time {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	return ds.last
+}
+
+// Idle returns how long the signal is known to have been unchanged since
+// the last timing delivered ended, allowing for the latency of the source.
+// It is 0 while timings are held back, since the signal has changed since then.
+func (ds *DuplexSource) Idle() time.Duration {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	if ds.pending {
+		return 0
+	}
+	d := time.Since(ds.last) - ds.latency
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// run reads the timings from the source. The end time of each timing is
+// estimated by the clock, rather than taken from when it is read, since
+// a receiver may deliver timings in blocks.
+// When gating, each low period is held back until the next timing, so that
+// if a transmission starts during the following high period, the low
+// period can be merged with the gated timings.
+func (ds *DuplexSource) run(c <-chan time.Duration, send chan<- time.Duration, clock *Clock) {
+	defer ds.wg.Done()
+	defer close(send)
+	var (
+		level    int
+		held     time.Duration
+		acc      time.Duration
+		gating   bool
+		heldTime time.Time
+	)
+	deliver := func(d time.Duration, end time.Time) {
+		ds.lock.Lock()
+		ds.last = end
+		ds.lock.Unlock()
+		send <- d
+	}
+	for d := range c {
+		t := clock.Add(d)
+		start := t.Add(-d)
+		lv := level
+		level ^= 1
+		switch {
+		case d == Dropped:
+			// The number and length of the timings lost are unknown,
+			// so pass the marker on and start again from a low period.
+			if held != 0 {
+				deliver(held, heldTime)
+			}
+			level, held, acc, gating = 0, 0, 0, false
+			deliver(Dropped, t)
+		case !ds.gate:
+			deliver(d, t)
+		case ds.d.Active(start, t):
+			if !gating {
+				// The held period is low, so merge it with the gated timings.
+				gating, acc, held = true, held, 0
+			}
+			acc += d
+		case gating && lv == 0:
+			// End the gated period on a low timing.
+			acc += d
+		default:
+			if gating {
+				gating = false
+				deliver(acc, start)
+			} else if held != 0 {
+				deliver(held, heldTime)
+				held = 0
+			}
+			if lv == 0 {
+				held, heldTime = d, t
+			} else {
+				deliver(d, t)
+			}
+		}
+		ds.lock.Lock()
+		ds.pending = gating || held != 0
+		ds.lock.Unlock()
+	}
+	if gating {
+		deliver(acc, clock.Time())
+	} else if held != 0 {
+		deliver(held, heldTime)
+	}
+}
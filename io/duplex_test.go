@@ -0,0 +1,105 @@
+package io
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chanSource is a Source that delivers the timings written to it. Its
+// latency is long, so that the end of each timing is calculated from
+// the time the source was started rather than when it is read.
+type chanSource struct {
+	c chan time.Duration
+}
+
+func (s *chanSource) Start() (<-chan time.Duration, error) {
+	s.c = make(chan time.Duration, 100)
+	return s.c, nil
+}
+
+func (s *chanSource) Stop() {
+	close(s.c)
+}
+
+func (s *chanSource) Close() {
+}
+
+func (s *chanSource) Latency() time.Duration {
+	return time.Hour
+}
+
+// duplexRun passes the timings through a DuplexSource, with a transmission
+// active between the times given relative to the start of the timings,
+// and returns the timings delivered.
+func duplexRun(t *testing.T, gate bool, txStart, txEnd time.Duration, in []time.Duration) []time.Duration {
+	t.Helper()
+	d := NewDuplex(0)
+	src := &chanSource{}
+	ds := d.Source(src, gate)
+	c, err := ds.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	start := ds.Time()
+	if txEnd > txStart {
+		d.spans = []*span{{start: start.Add(txStart), end: start.Add(txEnd)}}
+	}
+	// The timings must have ended before they are read.
+	var total time.Duration
+	for _, v := range in {
+		if v > 0 {
+			total += v
+		}
+	}
+	time.Sleep(total + time.Millisecond)
+	for _, v := range in {
+		src.c <- v
+	}
+	ds.Stop()
+	var out []time.Duration
+	for v := range c {
+		out = append(out, v)
+	}
+	return out
+}
+
+func ms(v ...int) []time.Duration {
+	var d []time.Duration
+	for _, t := range v {
+		if t < 0 {
+			d = append(d, Dropped)
+		} else {
+			d = append(d, time.Duration(t)*time.Millisecond)
+		}
+	}
+	return d
+}
+
+func TestDuplexSource(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		gate           bool
+		txStart, txEnd int // Transmission, in milliseconds from the start
+		in, want       []time.Duration
+	}{
+		// Timings are passed through unchanged when not gating.
+		{"no gate", false, 9, 13, ms(6, 2, 2, 2, 2, 2, 2, 2, 2), ms(6, 2, 2, 2, 2, 2, 2, 2, 2)},
+		// The transmission starts during a low period.
+		{"start low", true, 9, 13, ms(6, 2, 2, 2, 2, 2, 2, 2, 2), ms(6, 2, 6, 2, 2, 2, 2)},
+		// The transmission starts during a high period, so it is merged with
+		// the low period before it, and it ends on a low period.
+		{"start high", true, 7, 11, ms(6, 2, 2, 2, 2, 2, 2, 2, 2), ms(14, 2, 2, 2, 2)},
+		// No transmission.
+		{"idle", true, 0, 0, ms(6, 2, 2, 2, 2), ms(6, 2, 2, 2, 2)},
+		// After timings are lost, the timings start again from a low period.
+		{"dropped", true, 0, 0, ms(6, 2, 2, -1, 3, 4, 5), ms(6, 2, 2, -1, 3, 4, 5)},
+		// The timings gated are discarded if timings are lost.
+		{"dropped while gating", true, 7, 12, ms(6, 2, 2, -1, 3, 4, 5), ms(-1, 3, 4, 5)},
+	} {
+		got := duplexRun(t, tc.gate, time.Duration(tc.txStart)*time.Millisecond, time.Duration(tc.txEnd)*time.Millisecond, tc.in)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, expected %v", tc.name, got, tc.want)
+		}
+	}
+}
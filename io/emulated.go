@@ -0,0 +1,556 @@
+// Receiver and transmitter running the PRU firmware on an emulated PRU.
+
+package io
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aamcrae/rf/emu"
+)
+
+const emuChunk = 200000 // Cycles run between checks for stopping
+
+// EmuTransmitter is a Sink that runs the transmitter firmware on an emulated PRU,
+// recording the output signal.
+type EmuTransmitter struct {
+	Gap    int
+	PRU    *emu.PRU
+	gpio   uint32
+	stream bool     // Stream signals too long for the PRU RAM
+	pol    Polarity // Output polarity and idle level
+	fw     *Firmware
+	sfw    *Firmware
+	lock   sync.Mutex
+	busy   chan struct{} // Held while a message is being sent
+	signal []uint64
+	stats  TxStats
+}
+
+// EmuReceiver is a Source that runs the receiver firmware on an emulated PRU,
+// with the input driven by a list of timings.
+type EmuReceiver struct {
+	BufCount int           // Number of receive buffers
+	BufSize  int           // Number of timings in each buffer
+	Flush    time.Duration // Time a partly filled buffer is held (0 to send only full buffers)
+	PRU      *emu.PRU
+	gpio     uint32
+	fw       *Firmware
+	timings  []time.Duration
+	buffer   int
+	edges    *edges // Nil until the start time has been read
+	pool     *bufPool
+	stats    RxStats
+	lock     sync.Mutex
+	running  bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewEmuTransmitter(gpio uint) *EmuTransmitter {
+	return &EmuTransmitter{Gap: defaultGap, PRU: emu.New(), gpio: uint32(gpio), fw: txFirmware, sfw: txsFirmware, busy: make(chan struct{}, 1)}
+}
+
+// SetFirmware sets the firmware run when a message is sent.
+// A streaming transmitter firmware replaces the firmware used
+// to stream long signals.
+func (tx *EmuTransmitter) SetFirmware(fw *Firmware) error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if fw.Type == FirmwareTxStream {
+		if err := fw.check(FirmwareTxStream, txsLayout); err != nil {
+			return err
+		}
+		tx.sfw = fw
+		return nil
+	}
+	if err := fw.check(FirmwareTx, txLayout); err != nil {
+		return err
+	}
+	tx.fw = fw
+	return nil
+}
+
+// SetStream enables streaming of signals too long to fit in the PRU RAM.
+func (tx *EmuTransmitter) SetStream(on bool) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.stream = on
+}
+
+// SetPolarity sets the output polarity and idle level, and sets the output
+// to the idle level.
+func (tx *EmuTransmitter) SetPolarity(p Polarity) {
+	tx.busy <- struct{}{}
+	defer func() { <-tx.busy }()
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.pol = p
+	tx.PRU.SetOutput(level(tx.PRU.Reg[30], tx.gpio, p.idle()))
+}
+
+func (tx *EmuTransmitter) Close() {
+}
+
+// Send a message, running the firmware until it completes.
+func (tx *EmuTransmitter) Send(msg []int, repeats int) error {
+	return tx.SendPrecise(Durations(msg), repeats)
+}
+
+// SendPrecise sends a message without rounding the timings to microseconds.
+func (tx *EmuTransmitter) SendPrecise(msg []time.Duration, repeats int) error {
+	_, err := tx.transmit(context.Background(), tx.gpio, txMessage(msg, tx.Gap), repeats, Carrier{})
+	return err
+}
+
+// SendSequence sends a sequence of messages in one run of the firmware.
+func (tx *EmuTransmitter) SendSequence(seq []Entry) error {
+	_, err := sendSequence(context.Background(), tx, tx.gpio, seq)
+	return err
+}
+
+// SendAsync sends a message without blocking the caller.
+func (tx *EmuTransmitter) SendAsync(ctx context.Context, msg []time.Duration, repeats int) *Transmission {
+	return startTransmit(ctx, tx, tx.gpio, txMessage(msg, tx.Gap), repeats)
+}
+
+// SendSequenceAsync sends a sequence of messages without blocking the caller.
+func (tx *EmuTransmitter) SendSequenceAsync(ctx context.Context, seq []Entry) *Transmission {
+	return startSequence(ctx, tx, tx.gpio, seq)
+}
+
+// Output returns a Sink that sends messages using a different gpio output.
+// The output is set to the idle level.
+func (tx *EmuTransmitter) Output(gpio uint) Sink {
+	tx.busy <- struct{}{}
+	defer func() { <-tx.busy }()
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.PRU.SetOutput(level(tx.PRU.Reg[30], uint32(gpio), tx.pol.idle()))
+	return &output{tx: tx, gpio: uint32(gpio)}
+}
+
+// Stats returns the transmitter statistics. The time taken by
+// each transmission is the emulated time.
+func (tx *EmuTransmitter) Stats() TxStats {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return tx.stats
+}
+
+func (tx *EmuTransmitter) gap() int {
+	return tx.Gap
+}
+
+// transmit sends the signal on the gpio output, with the on periods
+// modulated by the carrier, returning the emulated time taken. If the
+// context is done before the signal has been sent, the emulation is
+// stopped and the output is set to the idle level.
+func (tx *EmuTransmitter) transmit(ctx context.Context, gpio uint32, signal []time.Duration, repeats int, c Carrier) (time.Duration, error) {
+	// Only one message can be sent at a time.
+	select {
+	case tx.busy <- struct{}{}:
+	case <-ctx.Done():
+		tx.lock.Lock()
+		tx.stats.add(0, ctx.Err())
+		tx.lock.Unlock()
+		return 0, ctx.Err()
+	}
+	defer func() { <-tx.busy }()
+	tx.lock.Lock()
+	fw, sfw, stream, pol := tx.fw, tx.sfw, tx.stream, tx.pol
+	tx.lock.Unlock()
+	p := tx.PRU
+	var err error
+	if stream && len(signal) > txMax(len(p.Ram)) {
+		if c.Freq != 0 {
+			err = fmt.Errorf("carrier modulation not supported when streaming")
+		} else {
+			err = tx.sendStream(ctx, sfw, gpio, pol, repeatSignal(signal, repeats))
+		}
+	} else {
+		err = tx.send(ctx, fw, gpio, pol, signal, repeats, c)
+	}
+	if err != nil {
+		p.SetOutput(level(p.Reg[30], gpio, pol.idle()))
+	}
+	d := p.Time()
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.signal = p.Output(uint(gpio))
+	if (p.Start>>gpio)&1 != pol.inactive() && len(tx.signal) > 0 {
+		// Drop the time before the firmware set the output inactive.
+		tx.signal = tx.signal[1:]
+	}
+	tx.stats.add(d, err)
+	return d, err
+}
+
+// send runs the transmitter firmware to send the signal.
+func (tx *EmuTransmitter) send(ctx context.Context, fw *Firmware, gpio uint32, pol Polarity, signal []time.Duration, repeats int, c Carrier) error {
+	p := tx.PRU
+	p.Load(fw.Code)
+	tout, err := txParams(p.Ram, p.Order, gpio, pol, signal, repeats, c, emu.MicroSeconds2Ticks)
+	if err != nil {
+		return err
+	}
+	done := false
+	p.Event = func(ev int) {
+		if ev == tx_event {
+			done = true
+		}
+	}
+	// Timeout is twice the expected transmission time
+	if err := tx.run(ctx, tout*2); err != nil {
+		return err
+	}
+	if !done {
+		return fmt.Errorf("transmit timeout (%s)", p.Time())
+	}
+	return nil
+}
+
+// sendStream sends a signal using the streaming firmware, refilling
+// each buffer as the firmware finishes sending it.
+func (tx *EmuTransmitter) sendStream(ctx context.Context, sfw *Firmware, gpio uint32, pol Polarity, signal []time.Duration) error {
+	p := tx.PRU
+	p.Load(sfw.Code)
+	s := newTxStream(p.Ram, p.Order, gpio, pol, signal, emu.MicroSeconds2Ticks)
+	s.fill()
+	s.fill()
+	var tout time.Duration
+	for _, t := range signal {
+		tout += t
+	}
+	p.Event = func(ev int) {
+		if ev == tx_event {
+			s.sent()
+			s.fill()
+		}
+	}
+	// Timeout is twice the expected transmission time
+	if err := tx.run(ctx, tout*2); err != nil {
+		return err
+	}
+	if !p.Halted {
+		return fmt.Errorf("transmit timeout (%s)", p.Time())
+	}
+	if n := s.underruns(); n > 0 {
+		return fmt.Errorf("transmit underrun (%d buffers not ready)", n)
+	}
+	return nil
+}
+
+// run runs the firmware until it halts or the timeout is reached,
+// checking between chunks of cycles whether the context is done.
+func (tx *EmuTransmitter) run(ctx context.Context, timeout time.Duration) error {
+	p := tx.PRU
+	end := uint64(emu.MicroSeconds2Ticks(int(timeout/time.Microsecond) + 1))
+	for !p.Halted && p.Cycle < end {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		next := p.Cycle + emuChunk
+		if next > end {
+			next = end
+		}
+		if err := p.Run(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signal returns the output of the last message sent, as the number
+// of cycles between each change of the output, starting with the
+// transmitter off (the output low, unless it is active low).
+// The output is the gpio the message was sent on.
+func (tx *EmuTransmitter) Signal() []uint64 {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return tx.signal
+}
+
+// NewEmuReceiver creates a receiver with an input signal
+// that starts low, and changes after each timing.
+func NewEmuReceiver(gpio uint, timings []time.Duration) *EmuReceiver {
+	return &EmuReceiver{BufCount: bufCount, BufSize: rxBufferSize, Flush: rxFlush, PRU: emu.New(), gpio: uint32(gpio), fw: rxFirmware, timings: timings}
+}
+
+// SetFirmware sets the firmware run when the receiver is started.
+func (rx *EmuReceiver) SetFirmware(fw *Firmware) error {
+	if err := fw.check(FirmwareRx, rxLayout); err != nil {
+		return err
+	}
+	rx.fw = fw
+	return nil
+}
+
+func (rx *EmuReceiver) Close() {
+}
+
+// Start the receiver firmware. The channel is closed once the
+// input signal has completed and the last partly filled buffer
+// has been sent, or the receiver is stopped.
+func (rx *EmuReceiver) Start() (<-chan time.Duration, error) {
+	bc, err := rx.StartBatch()
+	if err != nil {
+		return nil, err
+	}
+	send := make(chan time.Duration, 200)
+	go unbatch(bc, send, rx.Release)
+	return send, nil
+}
+
+// StartBatch starts the receiver firmware, delivering the
+// timings from each buffer as a block.
+func (rx *EmuReceiver) StartBatch() (<-chan []time.Duration, error) {
+	p := rx.PRU
+	if err := rxCheck(rxParamSize, rxRecord, rx.BufCount, rx.BufSize, len(rx.PRU.Ram)); err != nil {
+		return nil, err
+	}
+	p.Load(rx.fw.Code)
+	rxParams(p.Ram, p.Order, rx.gpio, rx.BufCount, rx.BufSize, rxFlushTicks(rx.Flush, emu.MicroSeconds2Ticks))
+	rx.buffer = 0
+	rx.edges = nil
+	end := emuFlushEnd(rx.Flush)
+	cycles := make([]uint64, len(rx.timings))
+	for i, t := range rx.timings {
+		cycles[i] = uint64(emu.MicroSeconds2Ticks(1)) * uint64(t) / uint64(time.Microsecond)
+		end += cycles[i]
+	}
+	p.Input = emu.Signal(uint(rx.gpio), 0, cycles)
+	if rx.pool == nil || rx.pool.size != rx.BufSize {
+		rx.pool = newBufPool(rx.BufCount, rx.BufSize)
+	}
+	send := make(chan []time.Duration, 2)
+	rx.lock.Lock()
+	rx.stop = make(chan struct{})
+	rx.running = true
+	rx.lock.Unlock()
+	p.Event = func(ev int) {
+		if ev == rx_event {
+			rx.fullBuffer(send)
+		}
+	}
+	rx.wg.Add(1)
+	go rx.run(send, end)
+	return send, nil
+}
+
+// Stop the receiver. Stopping a receiver that is not running does nothing.
+func (rx *EmuReceiver) Stop() {
+	rx.lock.Lock()
+	if rx.running {
+		close(rx.stop)
+		rx.running = false
+	}
+	rx.lock.Unlock()
+	rx.wg.Wait()
+}
+
+// Release returns a block received from StartBatch for reuse.
+func (rx *EmuReceiver) Release(b []time.Duration) {
+	rx.pool.put(b)
+}
+
+func (rx *EmuReceiver) run(send chan []time.Duration, end uint64) {
+	defer rx.wg.Done()
+	defer close(send)
+	p := rx.PRU
+	for !p.Halted && p.Cycle < end {
+		select {
+		case <-rx.stop:
+			return
+		default:
+		}
+		if err := p.Run(p.Cycle + emuChunk); err != nil {
+			return
+		}
+	}
+}
+
+// Stats returns the receiver statistics. Since the emulated
+// receiver waits for each buffer to be read, there are no overflows.
+func (rx *EmuReceiver) Stats() RxStats {
+	rx.lock.Lock()
+	defer rx.lock.Unlock()
+	return rx.stats
+}
+
+// emuFlushEnd returns the cycles run after the end of the input signals,
+// so that the last partly filled buffer is sent.
+func emuFlushEnd(flush time.Duration) uint64 {
+	if flush <= 0 {
+		return 0
+	}
+	return uint64(emu.MicroSeconds2Ticks(int(flush/time.Microsecond) + 1))
+}
+
+// fullBuffer sends the timings from a buffer the firmware has sent.
+func (rx *EmuReceiver) fullBuffer(send chan []time.Duration) {
+	p := rx.PRU
+	rx.lock.Lock()
+	rx.stats.Buffers++
+	rx.lock.Unlock()
+	b := rxBufStart + rx.buffer*4*rx.BufSize
+	if rx.edges == nil {
+		rx.edges = newEdges(p.Order.Uint32(p.Ram[rxStart:]))
+	}
+	n := rxFilled(p.Ram, p.Order, rxParamSize, rx.buffer)
+	blk := rx.edges.timings(rx.pool.get(), p.Ram[b:], p.Order, n, func(t uint32) time.Duration {
+		return emu.Duration(uint64(t))
+	})
+	select {
+	case send <- blk:
+	case <-rx.stop:
+		return
+	}
+	rx.buffer = (rx.buffer + 1) % rx.BufCount
+}
+
+// EmuMultiReceiver is a MultiSource that runs the multi-input receiver
+// firmware on an emulated PRU, with each input driven by a list of timings.
+type EmuMultiReceiver struct {
+	BufCount int           // Number of receive buffers
+	BufSize  int           // Number of words in each buffer
+	Flush    time.Duration // Time a partly filled buffer is held (0 to send only full buffers)
+	PRU      *emu.PRU
+	gpios    []uint
+	fw       *Firmware
+	inputs   [][]time.Duration
+	buffer   int
+	demux    *demux
+	stats    RxStats
+	lock     sync.Mutex
+	running  bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewEmuMultiReceiver creates a receiver with an input signal for each
+// gpio that starts low, and changes after each of its timings.
+func NewEmuMultiReceiver(gpios []uint, inputs [][]time.Duration) *EmuMultiReceiver {
+	return &EmuMultiReceiver{BufCount: bufCount, BufSize: rxBufferSize, Flush: rxFlush, PRU: emu.New(), gpios: gpios, fw: rxmFirmware, inputs: inputs}
+}
+
+// SetFirmware sets the firmware run when the receiver is started.
+func (rx *EmuMultiReceiver) SetFirmware(fw *Firmware) error {
+	if err := fw.check(FirmwareRxMulti, rxmLayout); err != nil {
+		return err
+	}
+	rx.fw = fw
+	return nil
+}
+
+func (rx *EmuMultiReceiver) Close() {
+}
+
+// Start the receiver firmware. The channel is closed once all the
+// input signals have completed and the last partly filled buffer
+// has been sent, or the receiver is stopped.
+func (rx *EmuMultiReceiver) Start() (<-chan Timing, error) {
+	p := rx.PRU
+	if err := rxCheck(rxmParamSize, rxmRecord, rx.BufCount, rx.BufSize, len(p.Ram)); err != nil {
+		return nil, err
+	}
+	p.Load(rx.fw.Code)
+	rxmParams(p.Ram, p.Order, rx.gpios, rx.BufCount, rx.BufSize, rxFlushTicks(rx.Flush, emu.MicroSeconds2Ticks))
+	rx.buffer = 0
+	rx.demux = nil
+	var end uint64
+	var signals []func(uint64) uint32
+	for i, in := range rx.inputs {
+		var t uint64
+		cycles := make([]uint64, len(in))
+		for j, d := range in {
+			cycles[j] = uint64(emu.MicroSeconds2Ticks(1)) * uint64(d) / uint64(time.Microsecond)
+			t += cycles[j]
+		}
+		if t > end {
+			end = t
+		}
+		signals = append(signals, emu.Signal(rx.gpios[i], 0, cycles))
+	}
+	end += emuFlushEnd(rx.Flush)
+	p.Input = func(cycle uint64) uint32 {
+		var v uint32
+		for _, s := range signals {
+			v |= s(cycle)
+		}
+		return v
+	}
+	send := make(chan Timing, 200)
+	rx.lock.Lock()
+	rx.stop = make(chan struct{})
+	rx.running = true
+	rx.lock.Unlock()
+	p.Event = func(ev int) {
+		if ev == rx_event {
+			rx.fullBuffer(send)
+		}
+	}
+	rx.wg.Add(1)
+	go rx.run(send, end)
+	return send, nil
+}
+
+// Stop the receiver, as for EmuReceiver.
+func (rx *EmuMultiReceiver) Stop() {
+	rx.lock.Lock()
+	if rx.running {
+		close(rx.stop)
+		rx.running = false
+	}
+	rx.lock.Unlock()
+	rx.wg.Wait()
+}
+
+func (rx *EmuMultiReceiver) run(send chan Timing, end uint64) {
+	defer rx.wg.Done()
+	defer close(send)
+	p := rx.PRU
+	for !p.Halted && p.Cycle < end {
+		select {
+		case <-rx.stop:
+			return
+		default:
+		}
+		if err := p.Run(p.Cycle + emuChunk); err != nil {
+			return
+		}
+	}
+}
+
+// Stats returns the receiver statistics.
+func (rx *EmuMultiReceiver) Stats() RxStats {
+	rx.lock.Lock()
+	defer rx.lock.Unlock()
+	return rx.stats
+}
+
+// fullBuffer sends the timings from a buffer the firmware has sent.
+func (rx *EmuMultiReceiver) fullBuffer(send chan Timing) {
+	p := rx.PRU
+	rx.lock.Lock()
+	rx.stats.Buffers++
+	rx.lock.Unlock()
+	if rx.demux == nil {
+		rx.demux = newDemux(rx.gpios, p.Order.Uint32(p.Ram[rxmStart:]), p.Order.Uint32(p.Ram[rxmStart+4:]))
+	}
+	b := rxBufStart + rx.buffer*4*rx.BufSize
+	n := rxFilled(p.Ram, p.Order, rxmParamSize, rx.buffer)
+	tm := rx.demux.records(nil, p.Ram[b:], p.Order, n, func(t uint32) time.Duration {
+		return emu.Duration(uint64(t))
+	})
+	for _, t := range tm {
+		select {
+		case send <- t:
+		case <-rx.stop:
+			return
+		}
+	}
+	rx.buffer = (rx.buffer + 1) % rx.BufCount
+}
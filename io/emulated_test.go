@@ -0,0 +1,98 @@
+package io
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/emu"
+)
+
+// TestEmuTransmitterTimings checks that each period sent by the
+// transmitter firmware is the timing rounded to an even number of
+// ticks, plus the one tick of the send loop that is not accounted for.
+func TestEmuTransmitterTimings(t *testing.T) {
+	sig := []time.Duration{
+		100 * time.Microsecond,
+		40 * time.Microsecond,
+		12345 * time.Nanosecond, // 2469 ticks, rounded up
+		1001 * time.Nanosecond,
+		60 * time.Nanosecond, // The shortest timing
+		20 * time.Nanosecond, // Lengthened to the shortest timing
+		3 * time.Millisecond,
+		50 * time.Microsecond,
+	}
+	// The last period ends when the output is set to the idle
+	// level, which is 3 cycles sooner than the next period would start.
+	want := []uint64{20001, 8001, 2471, 201, 13, 13, 600001, 9998}
+	tx := NewEmuTransmitter(3)
+	if _, err := tx.transmit(context.Background(), tx.gpio, sig, 1, Carrier{}); err != nil {
+		t.Fatalf("transmit: %v", err)
+	}
+	got := tx.Signal()
+	// The signal ends with the time from the last change until the firmware halted.
+	if len(got) != len(want)+1 {
+		t.Fatalf("%d periods sent, expected %d", len(got), len(want)+1)
+	}
+	// The first period includes the time taken to start the firmware.
+	if got[0] < want[0] || got[0] > want[0]+50 {
+		t.Errorf("period 0 is %d cycles, expected %d", got[0], want[0])
+	}
+	for i := 1; i < len(want); i++ {
+		if got[i] != want[i] {
+			t.Errorf("period %d is %d cycles, expected %d", i, got[i], want[i])
+		}
+	}
+}
+
+// TestEmuReceiverTimings checks that the IEP timer ticks recorded by the
+// receiver firmware are converted to the timings of the input signal.
+func TestEmuReceiverTimings(t *testing.T) {
+	in := []time.Duration{
+		100 * time.Microsecond,
+		250 * time.Microsecond,
+		12345 * time.Nanosecond,
+		3 * time.Millisecond,
+		2 * time.Microsecond,
+		70 * time.Microsecond,
+	}
+	rx := NewEmuReceiver(0, in)
+	c, err := rx.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var got []time.Duration
+	for d := range c {
+		got = append(got, d)
+	}
+	if len(got) != len(in) {
+		t.Fatalf("%d timings received, expected %d", len(got), len(in))
+	}
+	// The input is sampled every few cycles.
+	const tolerance = 100 * time.Nanosecond
+	for i, d := range got {
+		if d < in[i]-tolerance || d > in[i]+tolerance {
+			t.Errorf("timing %d is %s, expected %s", i, d, in[i])
+		}
+	}
+}
+
+// TestEmuStream checks that a signal too long for the PRU RAM is streamed,
+// with the timings kept across the switch between buffers.
+func TestEmuStream(t *testing.T) {
+	tx := NewEmuTransmitter(0)
+	tx.SetStream(true)
+	sig := seqMsg(3*txMax(len(tx.PRU.Ram)) + 1)
+	if _, err := tx.transmit(context.Background(), tx.gpio, sig, 1, Carrier{}); err != nil {
+		t.Fatalf("transmit: %v", err)
+	}
+	got := tx.Signal()
+	conv := tickConv(emu.MicroSeconds2Ticks)
+	// The first period includes the time taken to start the firmware,
+	// and the last ends when the output is set to the idle level.
+	for i := 1; i < len(sig)-1; i++ {
+		if want := uint64(conv(sig[i])) + 1; got[i] != want {
+			t.Errorf("period %d is %d cycles, expected %d", i, got[i], want)
+		}
+	}
+}
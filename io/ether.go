@@ -0,0 +1,280 @@
+// Simulated radio medium connecting virtual transmitters and receivers.
+
+package io
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const etherPoll = time.Millisecond
+const etherDelay = 10 * time.Millisecond
+
+// Ether is a simulated radio medium. A message sent by any attached
+// transmitter is seen by every attached receiver, with optional
+// impairments applied separately for each receiver.
+// Transmissions that overlap in time collide, and the receivers
+// see the combination of the signals.
+type Ether struct {
+	Noise    float64       // Average number of noise pulses per second.
+	NoiseMin time.Duration // Minimum length of a noise pulse.
+	NoiseMax time.Duration // Maximum length of a noise pulse.
+	Jitter   time.Duration // Maximum random shift of each edge.
+	Dropout  float64       // Probability (0-1) that a pulse is not received.
+	Stretch  time.Duration // Time added to each pulse, as by many receiver modules.
+
+	lock       sync.Mutex
+	rand       *rand.Rand
+	epoch      time.Time
+	busyUntil  time.Duration
+	collisions int // Count of overlapping transmissions
+	rx         map[*EtherReceiver]struct{}
+}
+
+// interval is a period of time the signal is high, relative to the ether epoch.
+type interval struct {
+	start, end time.Duration
+}
+
+// EtherTransmitter is a Sink that sends to an Ether.
+type EtherTransmitter struct {
+	Gap   int
+	ether *Ether
+}
+
+// EtherReceiver is a Source that receives from an Ether.
+type EtherReceiver struct {
+	ether     *Ether
+	pending   []interval
+	level     int
+	lastEdge  time.Duration
+	processed time.Duration
+	nextNoise time.Duration // Time of the next noise pulse, or 0 if not scheduled
+	running   bool
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// DefaultEther is the medium used by the "sim" backend. The medium only
+// exists within a process, so a simulated transmitter and receiver must be
+// opened by the same program (e.g. the server with -tx sim and -rx sim),
+// rather than by separate programs such as the server and the sniffer.
+var DefaultEther = NewEther(1)
+
+// NewEther creates a noise free Ether, using seed for the random impairments.
+func NewEther(seed int64) *Ether {
+	e := new(Ether)
+	e.NoiseMin = 10 * time.Microsecond
+	e.NoiseMax = 200 * time.Microsecond
+	e.rand = rand.New(rand.NewSource(seed))
+	e.epoch = time.Now()
+	e.rx = make(map[*EtherReceiver]struct{})
+	return e
+}
+
+func (e *Ether) Transmitter() *EtherTransmitter {
+	return &EtherTransmitter{Gap: defaultGap, ether: e}
+}
+
+func (e *Ether) Receiver() *EtherReceiver {
+	return &EtherReceiver{ether: e}
+}
+
+// Collisions returns the count of transmissions that overlapped
+// an earlier transmission.
+func (e *Ether) Collisions() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.collisions
+}
+
+func (e *Ether) now() time.Duration {
+	return time.Since(e.epoch)
+}
+
+// transmit adds the high periods of a signal to each receiver,
+// and returns the time the transmission completes.
+func (e *Ether) transmit(signal []int) time.Duration {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	start := e.now()
+	var highs []interval
+	t := start
+	for i, v := range signal {
+		d := time.Duration(v) * time.Microsecond
+		if i%2 == 1 {
+			highs = append(highs, interval{t, t + d})
+		}
+		t += d
+	}
+	if start < e.busyUntil {
+		e.collisions++
+	}
+	if t > e.busyUntil {
+		e.busyUntil = t
+	}
+	for r := range e.rx {
+		for _, h := range highs {
+			if e.Dropout > 0 && e.rand.Float64() < e.Dropout {
+				continue
+			}
+			r.pending = append(r.pending, interval{e.jitter(h.start), e.jitter(h.end) + e.Stretch})
+		}
+	}
+	return t
+}
+
+// jitter randomly shifts a time by up to the configured jitter.
+func (e *Ether) jitter(t time.Duration) time.Duration {
+	if e.Jitter <= 0 {
+		return t
+	}
+	return t + time.Duration(e.rand.Int63n(int64(e.Jitter)*2+1)) - e.Jitter
+}
+
+// noise returns the time until the next noise pulse.
+// Noise must be enabled.
+func (e *Ether) noise() time.Duration {
+	return time.Duration(e.rand.ExpFloat64() / e.Noise * float64(time.Second))
+}
+
+func (tx *EtherTransmitter) Close() {
+}
+
+// Send the message, waiting until the transmission is complete.
+func (tx *EtherTransmitter) Send(msg []int, repeats int) error {
+	end := tx.ether.transmit(Expand(msg, repeats, tx.Gap))
+	time.Sleep(end - tx.ether.now())
+	return nil
+}
+
+func (rx *EtherReceiver) Close() {
+}
+
+// Start receiving. The timings are delivered a short time
+// after the signal is seen, to allow for jitter.
+func (rx *EtherReceiver) Start() (<-chan time.Duration, error) {
+	e := rx.ether
+	e.lock.Lock()
+	rx.pending = nil
+	rx.level = 0
+	rx.processed = e.now()
+	rx.lastEdge = rx.processed
+	rx.nextNoise = 0
+	e.rx[rx] = struct{}{}
+	rx.running = true
+	rx.stop = make(chan struct{})
+	rx.wg.Add(1)
+	e.lock.Unlock()
+	send := make(chan time.Duration, 200)
+	go rx.run(send)
+	return send, nil
+}
+
+// Latency returns the longest time between an edge and the
+// delivery of the timing it ends.
+func (rx *EtherReceiver) Latency() time.Duration {
+	return etherDelay + etherPoll
+}
+
+// Stop the receiver. Stopping a receiver that is not running does nothing.
+func (rx *EtherReceiver) Stop() {
+	rx.ether.lock.Lock()
+	delete(rx.ether.rx, rx)
+	if rx.running {
+		close(rx.stop)
+		rx.running = false
+	}
+	rx.ether.lock.Unlock()
+	rx.wg.Wait()
+}
+
+func (rx *EtherReceiver) run(send chan time.Duration) {
+	defer rx.wg.Done()
+	defer close(send)
+	tick := time.NewTicker(etherPoll)
+	defer tick.Stop()
+	for {
+		select {
+		case <-rx.stop:
+			return
+		case <-tick.C:
+		}
+		for _, d := range rx.edges() {
+			select {
+			case send <- d:
+			case <-rx.stop:
+				return
+			}
+		}
+	}
+}
+
+// edges returns the timings of the edges seen since the last call.
+func (rx *EtherReceiver) edges() []time.Duration {
+	e := rx.ether
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	now := e.now() - etherDelay
+	if now <= rx.processed {
+		return nil
+	}
+	if e.Noise <= 0 {
+		rx.nextNoise = 0
+	} else if rx.nextNoise == 0 {
+		// Noise may be enabled after the receiver is started.
+		rx.nextNoise = rx.processed + e.noise()
+	}
+	for e.Noise > 0 && rx.nextNoise < now {
+		n := e.NoiseMin
+		if e.NoiseMax > e.NoiseMin {
+			n += time.Duration(e.rand.Int63n(int64(e.NoiseMax - e.NoiseMin)))
+		}
+		rx.pending = append(rx.pending, interval{rx.nextNoise, rx.nextNoise + n})
+		rx.nextNoise += e.noise()
+	}
+	rx.processed = now
+	// Merge overlapping high periods.
+	sort.Slice(rx.pending, func(i, j int) bool { return rx.pending[i].start < rx.pending[j].start })
+	var merged []interval
+	for _, iv := range rx.pending {
+		if iv.end <= iv.start {
+			continue
+		}
+		if n := len(merged); n > 0 && iv.start <= merged[n-1].end {
+			if iv.end > merged[n-1].end {
+				merged[n-1].end = iv.end
+			}
+		} else {
+			merged = append(merged, iv)
+		}
+	}
+	var tm []time.Duration
+	rx.pending = rx.pending[:0]
+	for i, iv := range merged {
+		if iv.start >= now {
+			rx.pending = append(rx.pending, merged[i:]...)
+			break
+		}
+		if rx.level == 0 {
+			// A high period may have been shifted back before
+			// the end of a period already delivered.
+			if iv.start <= rx.lastEdge {
+				iv.start = rx.lastEdge + time.Nanosecond
+			}
+			tm = append(tm, iv.start-rx.lastEdge)
+			rx.lastEdge = iv.start
+			rx.level = 1
+		}
+		if iv.end >= now {
+			rx.pending = append(rx.pending, merged[i:]...)
+			break
+		}
+		tm = append(tm, iv.end-rx.lastEdge)
+		rx.lastEdge = iv.end
+		rx.level = 0
+	}
+	return tm
+}
@@ -0,0 +1,119 @@
+package io_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/io"
+	"github.com/aamcrae/rf/message"
+)
+
+// testMsg is a message of 500/1000us pulses, starting and ending high.
+func testMsg() message.Raw {
+	var m message.Raw
+	for i := 0; i < 12; i++ {
+		m = append(m, 500, 1000)
+	}
+	return append(m, 500)
+}
+
+// listen starts the receiver, runs send, and returns the messages the
+// Listener extracts from the timings received.
+func listen(t *testing.T, rx io.Source, l *message.Listener, send func()) []message.Raw {
+	t.Helper()
+	c, err := rx.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var msgs []message.Raw
+	done := make(chan struct{})
+	go func() {
+		for d := range c {
+			if m := l.NextDuration(d); m != nil {
+				msgs = append(msgs, m)
+			}
+		}
+		close(done)
+	}()
+	send()
+	// Allow for the receiver delay.
+	time.Sleep(50 * time.Millisecond)
+	rx.Stop()
+	<-done
+	// The line is idle after the last message.
+	if m := l.Idle(l.Gap + 1); m != nil {
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestEtherLoopback(t *testing.T) {
+	e := io.NewEther(1)
+	e.Jitter = 20 * time.Microsecond
+	tx := e.Transmitter()
+	msg := testMsg()
+	l := message.NewListener()
+	msgs := listen(t, e.Receiver(), l, func() {
+		if err := tx.Send(msg, 3); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	})
+	if len(msgs) != 3 {
+		t.Fatalf("received %d messages, expected 3 (noise %d, runts %d)", len(msgs), l.Noise, l.Runt)
+	}
+	// The Listener does not return the first high period.
+	for i, m := range msgs {
+		if n := msg[1:].Equal(m, 10); n != len(msg)-1 {
+			t.Errorf("message %d: %d of %d timings match: %v", i, n, len(msg)-1, m)
+		}
+	}
+	if c := e.Collisions(); c != 0 {
+		t.Errorf("%d collisions, expected none", c)
+	}
+}
+
+func TestEtherNoise(t *testing.T) {
+	e := io.NewEther(2)
+	e.Noise = 500
+	e.NoiseMin = 2 * time.Microsecond
+	e.NoiseMax = 10 * time.Microsecond
+	tx := e.Transmitter()
+	l := message.NewListener()
+	listen(t, e.Receiver(), l, func() {
+		tx.Send(testMsg(), 5)
+	})
+	if l.Noise == 0 {
+		t.Errorf("no messages discarded as noise")
+	}
+}
+
+func TestEtherNoNoise(t *testing.T) {
+	e := io.NewEther(3)
+	rx := e.Receiver()
+	c, err := rx.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	rx.Stop()
+	for d := range c {
+		t.Errorf("unexpected timing %s", d)
+	}
+}
+
+func TestEtherCollision(t *testing.T) {
+	e := io.NewEther(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Transmitter().Send(testMsg(), 1)
+		}()
+	}
+	wg.Wait()
+	if c := e.Collisions(); c != 1 {
+		t.Errorf("%d collisions, expected 1", c)
+	}
+}
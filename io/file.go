@@ -0,0 +1,74 @@
+// File based receiver and transmitter.
+
+package io
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/scanner"
+	"time"
+)
+
+// FileSink appends the transmitted signal to a file as a line of
+// comma separated microsecond timings, the same format that
+// is read by a file Source.
+type FileSink struct {
+	Gap  int
+	lock sync.Mutex
+	f    *os.File
+}
+
+// NewFileSource reads a file of comma separated microsecond timings,
+// and returns a Source that plays them back.
+func NewFileSource(name string) (*MemorySource, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tm []time.Duration
+	var s scanner.Scanner
+	s.Init(f)
+	s.Whitespace |= 1 << ','
+	s.Mode |= scanner.ScanInts
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		if tok == '-' {
+			return nil, fmt.Errorf("%s: %s: negative timing", name, s.Position)
+		}
+		if tok == scanner.Int {
+			v, err := strconv.ParseInt(s.TokenText(), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: illegal value: %v", name, s.Position, err)
+			}
+			tm = append(tm, time.Duration(v)*time.Microsecond)
+		}
+	}
+	return NewMemorySource(tm), nil
+}
+
+func NewFileSink(name string) (*FileSink, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{Gap: defaultGap, f: f}, nil
+}
+
+func (fs *FileSink) Close() {
+	fs.f.Close()
+}
+
+func (fs *FileSink) Send(msg []int, repeats int) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	var s strings.Builder
+	for _, t := range Expand(msg, repeats, fs.Gap) {
+		fmt.Fprintf(&s, "%d,", t)
+	}
+	s.WriteString("\n")
+	_, err := fs.f.WriteString(s.String())
+	return err
+}
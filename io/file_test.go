@@ -0,0 +1,34 @@
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(s string) string {
+		name := filepath.Join(dir, "timings")
+		if err := os.WriteFile(name, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	src, err := NewFileSource(write("500,1000,\n500, 1000\n"))
+	if err != nil {
+		t.Fatalf("NewFileSource: %v", err)
+	}
+	want := []time.Duration{500 * time.Microsecond, time.Millisecond, 500 * time.Microsecond, time.Millisecond}
+	if !reflect.DeepEqual(src.timings, want) {
+		t.Errorf("timings %v, expected %v", src.timings, want)
+	}
+	// A negative timing is scanned as a separate sign, which is rejected.
+	for _, s := range []string{"500,-5,1000", "-5"} {
+		if _, err := NewFileSource(write(s)); err == nil {
+			t.Errorf("%q: negative timing accepted", s)
+		}
+	}
+}
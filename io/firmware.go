@@ -0,0 +1,111 @@
+// PRU firmware images, and loading firmware from a file.
+
+//go:generate go run ../assemble -p io -img prurx.img prurx.p
+//go:generate go run ../assemble -p io -img prutx.img prutx.p
+//go:generate go run ../assemble -p io -img prurxm.img prurxm.p
+//go:generate go run ../assemble -p io -img prutxs.img prutxs.p
+
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	stdio "io"
+	"os"
+)
+
+// Firmware types.
+const (
+	FirmwareRx       = 1
+	FirmwareTx       = 2
+	FirmwareRxMulti  = 3
+	FirmwareTxStream = 4
+)
+
+const fwMagic = 0x46555250 // "PRUF"
+const fwMaxWords = 2048    // Size of PRU instruction RAM
+
+// Firmware is a PRU program, along with the type of firmware
+// and the parameter layout it expects in unit RAM.
+type Firmware struct {
+	Type    uint32
+	Layout  uint32 // Parameter layout
+	Version uint32 // Version of the firmware
+	Code    []uint32
+}
+
+// Firmware compiled into the package.
+var rxFirmware = &Firmware{Type: FirmwareRx, Layout: rxLayout, Version: 3, Code: prurx_img}
+var txFirmware = &Firmware{Type: FirmwareTx, Layout: txLayout, Version: 2, Code: prutx_img}
+var txsFirmware = &Firmware{Type: FirmwareTxStream, Layout: txsLayout, Version: 3, Code: prutxs_img}
+var rxmFirmware = &Firmware{Type: FirmwareRxMulti, Layout: rxmLayout, Version: 2, Code: prurxm_img}
+
+// ReadFirmware reads a firmware file. The file has a header of
+// little endian 32 bit words (magic, type, layout, version, code length),
+// followed by the code.
+func ReadFirmware(name string) (*Firmware, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var hdr [5]uint32
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("%s: header: %v", name, err)
+	}
+	if hdr[0] != fwMagic {
+		return nil, fmt.Errorf("%s: not a firmware file", name)
+	}
+	if hdr[4] == 0 || hdr[4] > fwMaxWords {
+		return nil, fmt.Errorf("%s: illegal code length (%d)", name, hdr[4])
+	}
+	fw := &Firmware{Type: hdr[1], Layout: hdr[2], Version: hdr[3], Code: make([]uint32, hdr[4])}
+	if err := binary.Read(r, binary.LittleEndian, fw.Code); err != nil {
+		return nil, fmt.Errorf("%s: code: %v", name, err)
+	}
+	return fw, nil
+}
+
+// Write the firmware in the format read by ReadFirmware.
+func (fw *Firmware) Write(w stdio.Writer) error {
+	hdr := []uint32{fwMagic, fw.Type, fw.Layout, fw.Version, uint32(len(fw.Code))}
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, fw.Code)
+}
+
+// check verifies that the firmware matches the type and parameter layout.
+func (fw *Firmware) check(fwType, layout uint32) error {
+	if fw.Type != fwType {
+		return fmt.Errorf("firmware type %d, expected %d", fw.Type, fwType)
+	}
+	if fw.Layout != layout {
+		return fmt.Errorf("firmware parameter layout %d, expected %d", fw.Layout, layout)
+	}
+	return nil
+}
+
+// FirmwareLoader is implemented by receivers and transmitters that can run alternative firmware.
+type FirmwareLoader interface {
+	SetFirmware(fw *Firmware) error
+}
+
+// LoadFirmware reads a firmware file and sets it as the firmware
+// to be run by a receiver or transmitter.
+func LoadFirmware(dev interface{}, name string) error {
+	l, ok := dev.(FirmwareLoader)
+	if !ok {
+		return fmt.Errorf("%s: firmware cannot be loaded on this device", name)
+	}
+	fw, err := ReadFirmware(name)
+	if err != nil {
+		return err
+	}
+	if err := l.SetFirmware(fw); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Layout of the parameters and buffers in the PRU unit RAM for each firmware.
+
+package io
+
+// Parameter layouts written to unit RAM by this package.
+// These must be changed whenever the parameter block changes.
+const rxLayout = 3
+const rxmLayout = 2
+const txLayout = 3
+const txsLayout = 2
+
+// Receiver firmware.
+const rx_event = 19
+const rxBufferSize = 128 // Default timings in each buffer (512 bytes)
+const bufCount = 8       // Default number of buffers (4K)
+const rxBufStart = 0x100 // Address of first buffer
+const rxStart = 20       // Address of IEP timer value when the receiver started
+const rxParamSize = 24   // Size of parameters before the buffer addresses
+const rxRecord = 1       // Words stored for each edge (timestamp)
+
+// Multi-input receiver firmware, using the receiver buffers.
+const rxmStart = 20     // Address of IEP timer value and input bits at start
+const rxmParamSize = 28 // Size of parameters before the buffer addresses
+const rxmRecord = 2     // Words stored for each change (timestamp and input bits)
+
+// Transmitter firmware.
+const tx_event = 18
+const txDataStart = 36 // Address of transmit data
+
+// Streaming transmitter firmware.
+const txsBufStart = 0x20 // Address of the first streaming buffer
+const txsUnderrun = 16   // Address of the streaming underrun count
@@ -0,0 +1,211 @@
+// Listen before talk: deferring transmissions while the channel is busy.
+
+package io
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const lbtNoisePenalty = 2 // Reduction of the run for each noise pulse
+
+// ChannelMonitor detects activity on the channel from the timings seen
+// by a receiver, so that transmissions can be deferred while another
+// transmitter is sending. Activity is a run of timings of a plausible
+// length for a message. Noise pulses shorter than a message timing count
+// against the run, so that a message broken up by the occasional noise pulse
+// is still detected, but noise alone is not. A timing longer than any in a
+// message ends the run.
+// Activity is only seen once the receiver delivers the timings, so another
+// transmitter may be missed if it starts less than the latency of the
+// receiver (see Latency) before a transmission. A receiver that delivers
+// timings in large blocks cannot be used to detect activity in time.
+type ChannelMonitor struct {
+	MinPulse time.Duration // Shortest timing of a message
+	MaxPulse time.Duration // Longest timing within a message
+	MinRun   int           // Run of message timings that indicates activity
+	Hold     time.Duration // Time the channel remains busy after the last message timing
+	Backoff  time.Duration // Longest random back-off when the channel is busy
+	MaxDefer time.Duration // Longest time a transmission is deferred
+	lock     sync.Mutex
+	run      int       // Message timings seen, less the noise penalty
+	busy     time.Time // Time the channel becomes clear
+	stats    ChannelStats
+}
+
+// ChannelStats holds the statistics for a ChannelMonitor.
+type ChannelStats struct {
+	Checked  int           // Transmissions that checked the channel
+	Deferred int           // Transmissions deferred because the channel was busy
+	Forced   int           // Transmissions sent with the channel busy after the maximum deferral
+	Delay    time.Duration // Total time transmissions were deferred
+	MaxDelay time.Duration // Longest time a transmission was deferred
+}
+
+// lbtSink defers sending until the channel is clear.
+type lbtSink struct {
+	Sink
+	m *ChannelMonitor
+}
+
+// NewChannelMonitor returns a ChannelMonitor with defaults
+// suitable for typical 433MHz remote controls and sensors.
+func NewChannelMonitor() *ChannelMonitor {
+	return &ChannelMonitor{
+		MinPulse: 100 * time.Microsecond,
+		MaxPulse: 10 * time.Millisecond,
+		MinRun:   8,
+		Hold:     20 * time.Millisecond,
+		Backoff:  50 * time.Millisecond,
+		MaxDefer: 2 * time.Second,
+	}
+}
+
+// Sink returns a Sink that waits for the channel to be clear before sending.
+func (m *ChannelMonitor) Sink(s Sink) Sink {
+	return &lbtSink{Sink: s, m: m}
+}
+
+// Watch starts the source, and monitors the timings received
+// until the source is stopped.
+func (m *ChannelMonitor) Watch(src Source) error {
+	c, err := src.Start()
+	if err != nil {
+		return err
+	}
+	clock := NewClock(src)
+	go func() {
+		for d := range c {
+			m.Add(d, clock.Add(d))
+		}
+	}()
+	return nil
+}
+
+// Add records a timing received, ending at the time given.
+// Lost timings (Dropped) end the run.
+func (m *ChannelMonitor) Add(d time.Duration, end time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	switch {
+	case d == Dropped || d > m.MaxPulse:
+		m.run = 0
+		return
+	case d < m.MinPulse:
+		m.run -= lbtNoisePenalty
+		if m.run < 0 {
+			m.run = 0
+		}
+		return
+	}
+	m.run++
+	if m.run >= m.MinRun && end.Add(m.Hold).After(m.busy) {
+		m.busy = end.Add(m.Hold)
+	}
+}
+
+// Busy returns true if another transmitter is active.
+func (m *ChannelMonitor) Busy() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return time.Now().Before(m.busy)
+}
+
+// Wait waits until the channel is clear, backing off for a random time each
+// time it is found busy. After MaxDefer, Wait returns even if the channel is
+// still busy. The time spent waiting is returned.
+func (m *ChannelMonitor) Wait(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	var err error
+	deferred, forced := false, false
+	for m.Busy() {
+		deferred = true
+		left := m.MaxDefer - time.Since(start)
+		if left <= 0 {
+			forced = true
+			break
+		}
+		b := m.Backoff/2 + time.Duration(rand.Int63n(int64(m.Backoff/2)+1))
+		if b > left {
+			b = left
+		}
+		t := time.NewTimer(b)
+		select {
+		case <-t.C:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		t.Stop()
+		break
+	}
+	d := time.Since(start)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stats.Checked++
+	if deferred {
+		m.stats.Deferred++
+		m.stats.Delay += d
+		if d > m.stats.MaxDelay {
+			m.stats.MaxDelay = d
+		}
+	}
+	if forced {
+		m.stats.Forced++
+	}
+	return d, err
+}
+
+// Stats returns the channel monitor statistics.
+func (m *ChannelMonitor) Stats() ChannelStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stats
+}
+
+func (ls *lbtSink) Send(msg []int, repeats int) error {
+	if _, err := ls.m.Wait(context.Background()); err != nil {
+		return err
+	}
+	return ls.Sink.Send(msg, repeats)
+}
+
+func (ls *lbtSink) SendPrecise(msg []time.Duration, repeats int) error {
+	if _, err := ls.m.Wait(context.Background()); err != nil {
+		return err
+	}
+	return SendPrecise(ls.Sink, msg, repeats)
+}
+
+func (ls *lbtSink) SendSequence(seq []Entry) error {
+	if _, err := ls.m.Wait(context.Background()); err != nil {
+		return err
+	}
+	return SendSequence(ls.Sink, seq)
+}
+
+func (ls *lbtSink) SendAsync(ctx context.Context, msg []time.Duration, repeats int) *Transmission {
+	return startAsync(ctx, func(ctx context.Context) (time.Duration, error) {
+		if _, err := ls.m.Wait(ctx); err != nil {
+			return 0, err
+		}
+		res := SendAsync(ctx, ls.Sink, msg, repeats).Wait()
+		return res.Duration, res.Err
+	})
+}
+
+func (ls *lbtSink) SendSequenceAsync(ctx context.Context, seq []Entry) *Transmission {
+	return startAsync(ctx, func(ctx context.Context) (time.Duration, error) {
+		if _, err := ls.m.Wait(ctx); err != nil {
+			return 0, err
+		}
+		res := SendSequenceAsync(ctx, ls.Sink, seq).Wait()
+		return res.Duration, res.Err
+	})
+}
+
+func (ls *lbtSink) gap() int {
+	return txGap(ls.Sink)
+}
@@ -0,0 +1,71 @@
+package io
+
+import (
+	"testing"
+	"time"
+)
+
+// lbtMsg returns a message of 500/1000us pulses, lasting about 75ms.
+func lbtMsg() []int {
+	var msg []int
+	for i := 0; i < 50; i++ {
+		msg = append(msg, 500, 1000)
+	}
+	return append(msg, 500)
+}
+
+// TestChannelMonitorDefer sends while a neighbouring transmitter is
+// sending, and checks that the transmission is deferred until it has finished.
+func TestChannelMonitorDefer(t *testing.T) {
+	e := NewEther(5)
+	m := NewChannelMonitor()
+	rx := e.Receiver()
+	if err := m.Watch(rx); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	defer rx.Stop()
+	done := make(chan struct{})
+	go func() {
+		e.Transmitter().Send(lbtMsg(), 1)
+		close(done)
+	}()
+	// Wait for the neighbour's message to start, and the receiver to deliver it.
+	for !m.Busy() {
+		select {
+		case <-done:
+			t.Fatalf("channel not busy while the neighbour is sending")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := m.Sink(e.Transmitter()).Send(lbtMsg(), 1); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-done
+	st := m.Stats()
+	if st.Checked != 1 || st.Deferred != 1 || st.Forced != 0 {
+		t.Errorf("%d transmissions checked, %d deferred, %d forced, expected 1, 1, 0", st.Checked, st.Deferred, st.Forced)
+	}
+	if c := e.Collisions(); c != 0 {
+		t.Errorf("%d collisions, expected none", c)
+	}
+}
+
+// TestChannelMonitorClear sends while the channel is clear, and
+// checks that the transmission is not deferred.
+func TestChannelMonitorClear(t *testing.T) {
+	e := NewEther(6)
+	e.Noise = 50
+	m := NewChannelMonitor()
+	rx := e.Receiver()
+	if err := m.Watch(rx); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	defer rx.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if err := m.Sink(e.Transmitter()).Send(lbtMsg(), 1); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if st := m.Stats(); st.Deferred != 0 {
+		t.Errorf("%d transmissions deferred on a clear channel", st.Deferred)
+	}
+}
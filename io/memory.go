@@ -0,0 +1,153 @@
+// In-memory receiver and transmitter.
+
+package io
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemorySource is a Source that plays back a list of timings.
+type MemorySource struct {
+	Realtime  bool // If set, each timing is delivered after the time has elapsed.
+	BatchSize int  // Number of timings in each block from StartBatch
+	timings   []time.Duration
+	lock      sync.Mutex
+	running   bool
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Sent is a record of a message sent to a MemorySink.
+type Sent struct {
+	Msg     []int
+	Repeats int
+}
+
+// MemorySink is a Sink that records the messages sent.
+type MemorySink struct {
+	Gap  int
+	lock sync.Mutex
+	sent []Sent
+}
+
+func NewMemorySource(timings []time.Duration) *MemorySource {
+	return &MemorySource{BatchSize: rxBufferSize, timings: timings}
+}
+
+func (m *MemorySource) Close() {
+}
+
+// Start playing the timings. The channel is closed when all
+// the timings have been sent, or the source is stopped.
+func (m *MemorySource) Start() (<-chan time.Duration, error) {
+	send := make(chan time.Duration, 200)
+	m.start()
+	go m.play(send)
+	return send, nil
+}
+
+// StartBatch plays the timings as blocks of BatchSize timings.
+// The blocks are slices of the original timings, and must not be modified.
+func (m *MemorySource) StartBatch() (<-chan []time.Duration, error) {
+	if m.BatchSize <= 0 {
+		return nil, fmt.Errorf("%d: illegal batch size", m.BatchSize)
+	}
+	send := make(chan []time.Duration, 2)
+	m.start()
+	go m.playBatch(send)
+	return send, nil
+}
+
+// Release is a no-op, since blocks are not reused.
+func (m *MemorySource) Release(b []time.Duration) {
+}
+
+// Stop the playback. Stopping a source that is not
+// playing does nothing.
+func (m *MemorySource) Stop() {
+	m.lock.Lock()
+	if m.running {
+		close(m.stop)
+		m.running = false
+	}
+	m.lock.Unlock()
+	m.wg.Wait()
+}
+
+func (m *MemorySource) start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stop = make(chan struct{})
+	m.running = true
+	m.wg.Add(1)
+}
+
+func (m *MemorySource) play(send chan time.Duration) {
+	defer m.wg.Done()
+	defer close(send)
+	for _, d := range m.timings {
+		if m.Realtime {
+			select {
+			case <-time.After(d):
+			case <-m.stop:
+				return
+			}
+		}
+		select {
+		case send <- d:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *MemorySource) playBatch(send chan []time.Duration) {
+	defer m.wg.Done()
+	defer close(send)
+	for i := 0; i < len(m.timings); i += m.BatchSize {
+		end := i + m.BatchSize
+		if end > len(m.timings) {
+			end = len(m.timings)
+		}
+		b := m.timings[i:end:end]
+		if m.Realtime {
+			var total time.Duration
+			for _, d := range b {
+				total += d
+			}
+			select {
+			case <-time.After(total):
+			case <-m.stop:
+				return
+			}
+		}
+		select {
+		case send <- b:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{Gap: defaultGap}
+}
+
+func (m *MemorySink) Close() {
+}
+
+func (m *MemorySink) Send(msg []int, repeats int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sent = append(m.sent, Sent{Msg: append([]int(nil), msg...), Repeats: repeats})
+	return nil
+}
+
+// Messages returns the messages that have been sent.
+func (m *MemorySink) Messages() []Sent {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Sent(nil), m.sent...)
+}
@@ -0,0 +1,144 @@
+// Receiving from several inputs at once.
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const rxmMaxChannels = 8 // Maximum number of inputs
+
+// Timing is a timing received on one channel of a MultiSource.
+type Timing struct {
+	Channel  int
+	Duration time.Duration
+}
+
+// MultiSource is a stream of timings from several inputs. The channel
+// of each timing is the index of the input, and the timings for
+// each channel follow the same conventions as a Source.
+type MultiSource interface {
+	Start() (<-chan Timing, error)
+	Stop()
+	Close()
+}
+
+// OpenMultiSource creates a MultiSource for the gpio inputs from a
+// backend specification:
+//
+//	pru                - PRU based receiver monitoring all the gpio bits.
+//	emu:<name>,<name>  - emulated PRU receiver with the input timings
+//	                     for each channel read from a file.
+//	null               - no signal is received.
+func OpenMultiSource(spec string, gpios []uint) (MultiSource, error) {
+	if err := multiCheck(gpios); err != nil {
+		return nil, err
+	}
+	name, arg := splitSpec(spec)
+	switch name {
+	case "pru":
+		return openPRUMultiSource(gpios)
+	case "emu":
+		files := strings.Split(arg, ",")
+		if len(files) != len(gpios) {
+			return nil, fmt.Errorf("%s: %d input files for %d channels", spec, len(files), len(gpios))
+		}
+		var inputs [][]time.Duration
+		for _, f := range files {
+			s, err := NewFileSource(f)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, s.timings)
+		}
+		return NewEmuMultiReceiver(gpios, inputs), nil
+	case "null":
+		return NewEmuMultiReceiver(gpios, make([][]time.Duration, len(gpios))), nil
+	}
+	return nil, fmt.Errorf("%s: unknown multi-input receiver type", spec)
+}
+
+// multiCheck verifies the list of inputs.
+func multiCheck(gpios []uint) error {
+	if len(gpios) == 0 || len(gpios) > rxmMaxChannels {
+		return fmt.Errorf("%d inputs, must be 1-%d", len(gpios), rxmMaxChannels)
+	}
+	var mask uint32
+	for _, g := range gpios {
+		if g > 31 {
+			return fmt.Errorf("gpio %d out of range", g)
+		}
+		if mask&(1<<g) != 0 {
+			return fmt.Errorf("gpio %d used more than once", g)
+		}
+		mask |= 1 << g
+	}
+	return nil
+}
+
+// demux converts the records of input changes stored by the multi-input
+// firmware into timings for each channel. A channel that is high when
+// the receiver starts, or after timings are lost, is ignored until it
+// goes low, so that the timings for each channel start with a low period.
+type demux struct {
+	gpios []uint
+	bits  uint32   // Input bits of the last record
+	last  []uint32 // Time of the last edge on each channel
+	sync  []bool   // Channel is waiting for the input to go low
+	lost  bool     // Records were lost
+}
+
+func newDemux(gpios []uint, start, bits uint32) *demux {
+	d := &demux{gpios: gpios, bits: bits, last: make([]uint32, len(gpios)), sync: make([]bool, len(gpios))}
+	for ch, g := range gpios {
+		d.last[ch] = start
+		d.sync[ch] = bits&(1<<g) != 0
+	}
+	return d
+}
+
+// dropped marks that records were lost, appending Dropped for each channel.
+func (d *demux) dropped(out []Timing) []Timing {
+	for ch := range d.gpios {
+		out = append(out, Timing{ch, Dropped})
+	}
+	d.lost = true
+	return out
+}
+
+// records appends the timings from a buffer of n words, using
+// ticks to convert IEP timer ticks to a duration.
+func (d *demux) records(out []Timing, buf []byte, order binary.ByteOrder, n int, ticks func(uint32) time.Duration) []Timing {
+	for i := 0; i < n; i += 2 {
+		ts := order.Uint32(buf[i*4:])
+		bits := order.Uint32(buf[i*4+4:])
+		changed := bits ^ d.bits
+		d.bits = bits
+		if d.lost {
+			// The previous input state is unknown, so wait for each channel to go low.
+			for ch := range d.sync {
+				d.sync[ch] = true
+			}
+			d.lost = false
+			continue
+		}
+		for ch, g := range d.gpios {
+			if changed&(1<<g) == 0 {
+				continue
+			}
+			if d.sync[ch] {
+				if bits&(1<<g) == 0 {
+					d.sync[ch] = false
+					d.last[ch] = ts
+				}
+				continue
+			}
+			out = append(out, Timing{ch, ticks(ts - d.last[ch])})
+			d.last[ch] = ts
+		}
+	}
+	return out
+}
@@ -0,0 +1,25 @@
+//go:build !(linux && arm)
+
+package io
+
+import (
+	"errors"
+)
+
+// DefaultBackend is the receiver and transmitter type used by default.
+// The PRU is not available on this platform.
+const DefaultBackend = "null"
+
+var errNoPRU = errors.New("PRU is not supported on this platform")
+
+func openPRUSource(gpio uint) (Source, error) {
+	return nil, errNoPRU
+}
+
+func openPRUSink(gpio uint) (Sink, error) {
+	return nil, errNoPRU
+}
+
+func openPRUMultiSource(gpios []uint) (MultiSource, error) {
+	return nil, errNoPRU
+}
@@ -0,0 +1,92 @@
+// Transmitters with several outputs.
+
+package io
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputSink is a Sink that can drive several outputs, such as
+// transmitter modules for different bands. One message is sent at a time.
+type OutputSink interface {
+	Sink
+	Output(gpio uint) Sink
+}
+
+// transmitter is implemented by the PRU and emulated transmitters.
+// transmit returns the time taken to send the signal, with the high
+// periods modulated by the carrier.
+type transmitter interface {
+	transmit(ctx context.Context, gpio uint32, signal []time.Duration, repeats int, c Carrier) (time.Duration, error)
+	gap() int
+}
+
+// output is a Sink that sends messages on one output of a transmitter.
+type output struct {
+	tx   transmitter
+	gpio uint32
+}
+
+// Output returns a Sink that sends messages on the gpio output of s.
+// An error is returned if s does not support multiple outputs.
+func Output(s Sink, gpio uint) (Sink, error) {
+	o, ok := s.(OutputSink)
+	if !ok {
+		return nil, fmt.Errorf("transmitter does not support multiple outputs")
+	}
+	return o.Output(gpio), nil
+}
+
+// ParseOutputs parses a list of named outputs, as name=gpio,name=gpio...
+func ParseOutputs(spec string) (map[string]uint, error) {
+	outputs := make(map[string]uint)
+	if len(spec) == 0 {
+		return outputs, nil
+	}
+	for _, o := range strings.Split(spec, ",") {
+		name, g, ok := strings.Cut(o, "=")
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("%s: output must be name=gpio", o)
+		}
+		v, err := strconv.ParseUint(g, 10, 32)
+		if err != nil || v > 31 {
+			return nil, fmt.Errorf("%s: bad gpio number", o)
+		}
+		outputs[name] = uint(v)
+	}
+	return outputs, nil
+}
+
+func (o *output) Send(msg []int, repeats int) error {
+	return o.SendPrecise(Durations(msg), repeats)
+}
+
+func (o *output) SendPrecise(msg []time.Duration, repeats int) error {
+	_, err := o.tx.transmit(context.Background(), o.gpio, txMessage(msg, o.tx.gap()), repeats, Carrier{})
+	return err
+}
+
+func (o *output) SendSequence(seq []Entry) error {
+	_, err := sendSequence(context.Background(), o.tx, o.gpio, seq)
+	return err
+}
+
+func (o *output) SendAsync(ctx context.Context, msg []time.Duration, repeats int) *Transmission {
+	return startTransmit(ctx, o.tx, o.gpio, txMessage(msg, o.tx.gap()), repeats)
+}
+
+func (o *output) SendSequenceAsync(ctx context.Context, seq []Entry) *Transmission {
+	return startSequence(ctx, o.tx, o.gpio, seq)
+}
+
+func (o *output) gap() int {
+	return o.tx.gap()
+}
+
+// Close does nothing, since the transmitter is shared by all its outputs.
+func (o *output) Close() {
+}
@@ -0,0 +1,63 @@
+package io
+
+import (
+	"testing"
+)
+
+// TestOutput checks that messages sent using an output of the emulated
+// transmitter are sent on that output, leaving the other outputs idle.
+func TestOutput(t *testing.T) {
+	const gpio, other = 3, 5
+	pol := Polarity{ActiveLow: true}
+	tx := NewEmuTransmitter(gpio)
+	tx.SetPolarity(pol)
+	o, err := Output(tx, other)
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	// The new output is set to the idle level.
+	for _, g := range []uint{gpio, other} {
+		if l := tx.PRU.Reg[30] >> g & 1; l != pol.idle() {
+			t.Errorf("gpio %d: output %d before sending, expected %d", g, l, pol.idle())
+		}
+	}
+	msg := seqMsg(11)
+	for _, tc := range []struct {
+		name string
+		s    PreciseSink
+		gpio uint
+	}{
+		{"other", o.(PreciseSink), other},
+		{"default", tx, gpio},
+	} {
+		if err := tc.s.SendPrecise(msg, 2); err != nil {
+			t.Fatalf("%s: SendPrecise: %v", tc.name, err)
+		}
+		checkSignal(t, tc.name, tx, repeatSignal(txMessage(msg, tx.Gap), 2))
+		// The other output is not changed.
+		for _, g := range []uint{gpio, other} {
+			if g != tc.gpio && len(tx.PRU.Output(g)) != 1 {
+				t.Errorf("%s: gpio %d changed while sending on gpio %d", tc.name, g, tc.gpio)
+			}
+		}
+	}
+	// A Sink without multiple outputs is rejected.
+	if _, err := Output(&fakeSink{}, other); err == nil {
+		t.Errorf("Output of a single output Sink succeeded")
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	o, err := ParseOutputs("rf433=3,ir=14")
+	if err != nil {
+		t.Fatalf("ParseOutputs: %v", err)
+	}
+	if len(o) != 2 || o["rf433"] != 3 || o["ir"] != 14 {
+		t.Errorf("outputs %v, expected rf433=3, ir=14", o)
+	}
+	for _, s := range []string{"rf433", "=3", "ir=32", "ir=x"} {
+		if _, err := ParseOutputs(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
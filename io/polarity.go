@@ -0,0 +1,51 @@
+// Transmitter output polarity.
+
+package io
+
+import (
+	"fmt"
+)
+
+// Polarity is the level of a transmitter output. The zero value
+// is an output that is high while the transmitter is on, and
+// low between transmissions.
+type Polarity struct {
+	ActiveLow bool // The output is low while the transmitter is on
+	IdleHigh  bool // The output is left high between transmissions
+}
+
+// PolaritySetter is implemented by transmitters with a configurable output polarity.
+type PolaritySetter interface {
+	SetPolarity(p Polarity)
+}
+
+// SetPolarity sets the output polarity and idle level of a transmitter.
+func SetPolarity(dev interface{}, p Polarity) error {
+	s, ok := dev.(PolaritySetter)
+	if !ok {
+		return fmt.Errorf("transmitter does not support setting the output polarity")
+	}
+	s.SetPolarity(p)
+	return nil
+}
+
+// inactive returns the output level while the transmitter is off.
+func (p Polarity) inactive() uint32 {
+	if p.ActiveLow {
+		return 1
+	}
+	return 0
+}
+
+// idle returns the output level between transmissions.
+func (p Polarity) idle() uint32 {
+	if p.IdleHigh {
+		return 1
+	}
+	return 0
+}
+
+// level returns the output register value v with the gpio bit set to the level.
+func level(v, gpio, l uint32) uint32 {
+	return v&^(1<<gpio) | l<<gpio
+}
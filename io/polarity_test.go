@@ -0,0 +1,61 @@
+package io
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/emu"
+)
+
+// TestPolarity sends a message with each output polarity on an emulated
+// transmitter, and checks the output level while idle and while sending.
+func TestPolarity(t *testing.T) {
+	const gpio = 3
+	msg := []time.Duration{500 * time.Microsecond, time.Millisecond, 500 * time.Microsecond, time.Millisecond, 700 * time.Microsecond}
+	want := txMessage(msg, defaultGap)
+	for _, pol := range []Polarity{{}, {ActiveLow: true}, {IdleHigh: true}, {ActiveLow: true, IdleHigh: true}} {
+		tx := NewEmuTransmitter(gpio)
+		if err := SetPolarity(tx, pol); err != nil {
+			t.Fatalf("%+v: SetPolarity: %v", pol, err)
+		}
+		p := tx.PRU
+		if l := p.Reg[30] >> gpio & 1; l != pol.idle() {
+			t.Errorf("%+v: output %d before sending, expected %d", pol, l, pol.idle())
+		}
+		if err := tx.SendPrecise(msg, 1); err != nil {
+			t.Fatalf("%+v: SendPrecise: %v", pol, err)
+		}
+		if l := p.Reg[30] >> gpio & 1; l != pol.idle() {
+			t.Errorf("%+v: output %d after sending, expected %d", pol, l, pol.idle())
+		}
+		// Check the level of each period of the signal sent, skipping
+		// the idle period before the firmware sets the output inactive.
+		level := p.Start >> gpio & 1
+		out := p.Output(gpio)
+		if level != pol.inactive() {
+			out = out[1:]
+			level ^= 1
+		}
+		var on []time.Duration
+		for i, c := range out {
+			if i > 0 {
+				level ^= 1
+			}
+			if level != pol.inactive() {
+				on = append(on, emu.Duration(c))
+			}
+		}
+		var wantOn []time.Duration
+		for i := 1; i < len(want); i += 2 {
+			wantOn = append(wantOn, want[i])
+		}
+		if len(on) != len(wantOn) {
+			t.Fatalf("%+v: %d periods at the active level, expected %d (%v)", pol, len(on), len(wantOn), on)
+		}
+		for i, d := range on {
+			if d < wantOn[i]-time.Microsecond || d > wantOn[i]+time.Microsecond {
+				t.Errorf("%+v: active period %d is %s, expected %s", pol, i, d, wantOn[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux && arm
+
+package io
+
+// DefaultBackend is the receiver and transmitter type used by default.
+const DefaultBackend = "pru"
+
+func openPRUSource(gpio uint) (Source, error) {
+	return NewReceiver(gpio)
+}
+
+func openPRUSink(gpio uint) (Sink, error) {
+	return NewTransmitter(gpio)
+}
+
+func openPRUMultiSource(gpios []uint) (MultiSource, error) {
+	return NewMultiReceiver(gpios)
+}
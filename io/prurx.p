@@ -1,6 +1,6 @@
 ;
 ; Program to sample GPIO and capture
-; timing between transitions
+; the time of each transition
 ;
 .origin 0
 .entrypoint Start
@@ -12,52 +12,75 @@ u32 event    r0  Event to send when buffer is ready
 u32 gpio     r1  GPIO to use
 u32 count    r2  Count of buffers
 u32 size    r3  Buffer size
-u32 addr     ... Addresses of buffers
+u32 flush    r4  IEP ticks before a partly filled buffer is sent
+u32 start    ... IEP timer at start (written by firmware)
+u32 addr     ... Address of buffer
+u32 end      ... End of the timestamps in the buffer (written by firmware)
 ...
+
+ The buffers are filled with the IEP timer value at each
+ transition, alternately rising and falling edges.
+ A buffer is sent when it is full, or when the flush time
+ has passed since the first transition stored in it.
 */
-#define BUFS (4*4)  /* Start of buffer addresses */
+#define START (5*4) /* Start timestamp */
+#define BUFS (6*4)  /* Start of buffer addresses */
+#define IEP_CFG 0x00    /* IEP global configuration */
+#define IEP_COUNT 0x0C  /* IEP counter */
+#define IEP_ENABLE 0x11 /* Enable counter, increment by 1 each cycle */
 ;
 ; Register use
-; r4 - Number of buffers counter
 ; r5 - Current buffer address
-; r6 - timer counter
+; r6 - timestamp
 ; r7 - current buffer size
 ; r8 - zero
 ; r9 - Address of list of buffers
+; r10 - IEP configuration
+; r11 - Number of buffers counter
+; r12 - Time of first transition in buffer
+; r13 - GPIO bit mask
+; r14 - Current input level
+; r15 - Input level
 Start:
     MOV     r8, 0
-    LBBO    r0, r8, 0, BUFS ; Load parameters
+    LBBO    r0, r8, 0, START ; Load parameters
+    MOV     r10, IEP_ENABLE
+    SBCO    r10, C26, IEP_CFG, 4 ; Start IEP timer
+    MOV     r13, 1
+    LSL     r13, r13, r1    ; Mask of GPIO bit
+    MOV     r14, 0
     WBC     r31, r1       ; Wait until 0 is seen
-    MOV     r6, 14          ; r6 is counter
+    LBCO    r6, C26, IEP_COUNT, 4
+    SBBO    r6, r8, START, 4 ; Store start time
 StartLoop:
-; GPIO is 0
     MOV     r9, BUFS        ; Load start of buffer addresses
-    MOV     r4, r2          ; Count of buffer addresses
+    MOV     r11, r2         ; Count of buffer addresses
 NextBuf:
     LBBO    r5, r9, 0, 4    ; Load next buffer address
-    ADD     r9, r9, 4       ; Increment pointer to addresses
     MOV     r7, r3          ; Reload buffer size
 RLoop:
-ZeroBit:
-    ADD     r6, r6, 2       ; Add counter
-    QBBC    ZeroBit, r31, r1 ; Loop while bit is 0
-    SBBO    r6, r5, 0, 4    ; Store counter
-; GPIO is 1
-    MOV     r6, 8           ; r6 is counter
-OneBit:
-    ADD     r6, r6, 2       ; Add counter
-    QBBS    OneBit, r31, r1 ; Loop while bit is 0
-;
-    SBBO    r6, r5, 4, 4    ; Store counter
-    ADD     r5, r5, 8       ; Increment address
-    SUB     r7, r7, 2       ; Decrement buffer size
-    MOV     r6, 7          ; r6 is counter
+    AND     r15, r31, r13
+    QBNE    Edge, r15, r14  ; Input has changed
+    QBEQ    RLoop, r7, r3   ; Loop while buffer is empty
+    LBCO    r6, C26, IEP_COUNT, 4
+    SUB     r6, r6, r12     ; Time since first transition in buffer
+    QBLT    RLoop, r4, r6   ; Loop until flush time has passed
+    QBA     Send
+Edge:
+    LBCO    r6, C26, IEP_COUNT, 4
+    MOV     r14, r15
+    QBNE    Store, r7, r3
+    MOV     r12, r6         ; First transition in buffer
+Store:
+    SBBO    r6, r5, 0, 4    ; Store transition time
+    ADD     r5, r5, 4       ; Increment address
+    SUB     r7, r7, 1       ; Decrement buffer size
     QBNE    RLoop, r7, 0
-; Buffer is finished, signal event.
-    SBBO    r5, r9, 0, 4    ; Store counter
-    ADD     r9, r9, 4       ; Increment pointer to addresses
+; Buffer is full or flushed, signal event.
+Send:
+    SBBO    r5, r9, 4, 4    ; Store end of buffer
+    ADD     r9, r9, 8       ; Increment pointer to addresses
     OR      r31.b0, r0, 0x20
-    MOV     r6, 17          ; r6 is counter
-    SUB     r4, r4, 1
-    QBNE    NextBuf, r4, 0
+    SUB     r11, r11, 1
+    QBNE    NextBuf, r11, 0
     QBA     StartLoop
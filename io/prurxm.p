@@ -0,0 +1,84 @@
+;
+; Program to sample several GPIO inputs and capture
+; the time of each transition
+;
+.origin 0
+.entrypoint Start
+
+/*
+ Parameters:
+
+u32 event    r0  Event to send when buffer is ready
+u32 mask     r1  Mask of GPIO bits to monitor
+u32 count    r2  Count of buffers
+u32 size    r3  Buffer size
+u32 flush    r4  IEP ticks before a partly filled buffer is sent
+u32 start    ... IEP timer at start (written by firmware)
+u32 bits     ... Input bits at start (written by firmware)
+u32 addr     ... Address of buffer
+u32 end      ... End of the records in the buffer (written by firmware)
+...
+
+ Each time any of the inputs change, the IEP timer value
+ and the new input bits are stored in the buffer.
+ A buffer is sent when it is full, or when the flush time
+ has passed since the first record stored in it.
+*/
+#define START (5*4) /* Start timestamp and input bits */
+#define BUFS (7*4)  /* Start of buffer addresses */
+#define IEP_CFG 0x00    /* IEP global configuration */
+#define IEP_COUNT 0x0C  /* IEP counter */
+#define IEP_ENABLE 0x11 /* Enable counter, increment by 1 each cycle */
+;
+; Register use
+; r5 - Current buffer address
+; r7 - current buffer size
+; r8 - zero
+; r9 - Address of list of buffers
+; r10 - IEP configuration
+; r11 - previous input bits
+; r12 - timestamp
+; r13 - input bits
+; r14 - Number of buffers counter
+; r15 - Time of first record in buffer
+Start:
+    MOV     r8, 0
+    LBBO    r0, r8, 0, START ; Load parameters
+    MOV     r10, IEP_ENABLE
+    SBCO    r10, C26, IEP_CFG, 4 ; Start IEP timer
+    LBCO    r12, C26, IEP_COUNT, 4
+    AND     r13, r31, r1
+    MOV     r11, r13
+    SBBO    r12, r8, START, 8 ; Store start time and input bits
+StartLoop:
+    MOV     r9, BUFS        ; Load start of buffer addresses
+    MOV     r14, r2         ; Count of buffer addresses
+NextBuf:
+    LBBO    r5, r9, 0, 4    ; Load next buffer address
+    MOV     r7, r3          ; Reload buffer size
+RLoop:
+    AND     r13, r31, r1
+    QBNE    Change, r13, r11 ; Inputs have changed
+    QBEQ    RLoop, r7, r3   ; Loop while buffer is empty
+    LBCO    r12, C26, IEP_COUNT, 4
+    SUB     r12, r12, r15   ; Time since first record in buffer
+    QBLT    RLoop, r4, r12  ; Loop until flush time has passed
+    QBA     Send
+Change:
+    LBCO    r12, C26, IEP_COUNT, 4
+    MOV     r11, r13
+    QBNE    Store, r7, r3
+    MOV     r15, r12        ; First record in buffer
+Store:
+    SBBO    r12, r5, 0, 8   ; Store time and input bits
+    ADD     r5, r5, 8       ; Increment address
+    SUB     r7, r7, 2       ; Decrement buffer size
+    QBNE    RLoop, r7, 0
+; Buffer is full or flushed, signal event.
+Send:
+    SBBO    r5, r9, 4, 4    ; Store end of buffer
+    ADD     r9, r9, 8       ; Increment pointer to addresses
+    OR      r31.b0, r0, 0x20
+    SUB     r14, r14, 1
+    QBNE    NextBuf, r14, 0
+    QBA     StartLoop
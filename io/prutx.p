@@ -9,24 +9,46 @@ u32 gpio     r1  GPIO to use
 u32 repeat   r2  repeat count
 u32 length   r3  Length of data in words
 u32 data     r4  Address of data
+u32 high     r10 Carrier high time, or 0 for no carrier
+u32 low      r11 Carrier low time
+u32 inactive r13 GPIO level (0/1) when the transmitter is off
+u32 idle     r14 GPIO level (0/1) left after the message
 
-The GPIO initial value is 0, so the first time length
-is the length of time the bit is held low, then the next
-is how long it is held high, and so on.
+The output is first set to the inactive level, so the first time
+length is the length of time the transmitter is off, then the next
+is how long it is on, and so on. For an active low output,
+the inactive level is 1.
+If a carrier is used, the output is modulated while it is on,
+and the on time lengths are the number of carrier cycles.
 */
-#define DATA (5*4)  /* Start of data */
+#define CARRIER (5*4) /* Carrier parameters */
+#define LEVELS (7*4) /* Output levels */
+#define DATA (9*4)  /* Start of data */
 
 Start:
     MOV r8, 0
-    LBBO r0, r8, 0, DATA ; Load parameters
+    LBBO r0, r8, 0, CARRIER ; Load parameters
+    LBBO r10, r8, CARRIER, 8 ; Load carrier parameters
+    LBBO r13, r8, LEVELS, 8 ; Load output levels
+    MOV r15, 0
+    SET r15, r15, r1  ; r15 is GPIO mask
+;
+; Set the GPIO to the inactive level
+;
+    QBBC StartOff, r13, 0
+    SET r30, r30, r1
+    QBA RepeatLoop
+StartOff:
+    CLR r30, r30, r1
 RepeatLoop:
     MOV r6, r3        ; r6 is length
-    MOV r5, 0         ; r5 is bit (0/1)
+    MOV r5, r13       ; r5 is GPIO level (0/1)
     MOV r7, r4        ; r7 is data address
+    QBNE CSendLoop, r10, 0 ; Modulate with carrier
 SendLoop:
     LBBO r8, r7, 0, 4 ; Load next pulse time to r5
     ADD r7, r7, 4     ; Increment address
-    SUB r8, r8, 6       ; Adjust time for extra instructions
+    SUB r8, r8, 10    ; Adjust time for the 11 cycles of the other instructions
 ;
 ; Test current bit, and set GPIO to 0 or 1
 ;
@@ -35,6 +57,7 @@ SendLoop:
     QBA Delay
 SetOff:
     CLR r30, r30, r1  ; clear GPIO output
+    QBA Delay         ; Same time as the set path
 ;
 ; Delay loop, 2 instructions.
 Delay:
@@ -50,12 +73,55 @@ Delay:
 ;
 ; Repeat message
 ;
+RepeatEnd:
     SUB r2, r2, 1
     QBNE RepeatLoop, r2, 0
 ;
 ; Message repeat complete.
-; Ensure GPIO is off
+; Leave GPIO at the idle level
 ;
+    QBBS IdleHigh, r14, 0
     CLR r30, r30, r1 ; clear GPIO output
+    QBA Done
+IdleHigh:
+    SET r30, r30, r1 ; set GPIO output
+Done:
     OR r31.b0, r0, 0x20
     HALT
+;
+; Send loop when modulating with a carrier.
+; The low periods are sent in the same way as SendLoop.
+;
+CSendLoop:
+    LBBO r8, r7, 0, 4 ; Load next pulse time
+    ADD r7, r7, 4     ; Increment address
+    QBNE Carrier, r5, r13 ; Transmitter on
+    SUB r8, r8, 6     ; Adjust time for extra instructions
+    MOV r9, r10       ; Output is already inactive, keep timing
+CDelay:
+    SUB r8, r8, 2
+    QBNE CDelay, r8, 0 ; Delay loop
+CFlip:
+    XOR r5.b0, r5.b0, 1
+    SUB r6, r6, 1
+    QBNE CSendLoop, r6, 0
+    QBA RepeatEnd
+;
+; Send r8 carrier cycles. The high and low times
+; are adjusted for the instructions in each cycle.
+; The output is toggled, since it starts at the inactive level.
+;
+Carrier:
+    XOR r30, r30, r15 ; toggle GPIO output on
+    MOV r9, r10       ; r9 is high time
+    MOV r12, r11      ; r12 is low time
+    SUB r8, r8, 1     ; Count cycle
+CHigh:
+    SUB r9, r9, 2
+    QBNE CHigh, r9, 0
+    XOR r30, r30, r15 ; toggle GPIO output off
+CLow:
+    SUB r12, r12, 2
+    QBNE CLow, r12, 0
+    QBNE Carrier, r8, 0
+    QBA CFlip
@@ -0,0 +1,86 @@
+.origin 0
+.entrypoint Start
+/*
+    Parameters:
+u32 event    r0  Event to send when a buffer has been sent
+u32 gpio     r1  GPIO to use
+u32 buf0     r2  Address of first buffer
+u32 buf1     r3  Address of second buffer
+u32 underrun ... Count of times a buffer was not ready (written by firmware)
+u32 inactive r14 GPIO level (0/1) when the transmitter is off
+u32 idle     r15 GPIO level (0/1) left after the last buffer
+
+Each buffer starts with the count of timings in the buffer,
+followed by the timings. Bit 31 of the count is set on the last buffer.
+The host fills a buffer, then sets the count. Once the buffer
+has been sent, the count is cleared and the event is sent.
+The first time length is the length of time the GPIO is held
+at the inactive level, then the next is how long it is held
+at the active level, and so on.
+*/
+#define PARAMS (4*4)     /* Size of parameters */
+#define UNDERRUN (4*4)   /* Underrun count */
+#define LEVELS (5*4)     /* Output levels */
+Start:
+    MOV r12, 0        ; r12 is zero
+    LBBO r0, r12, 0, PARAMS ; Load parameters
+    LBBO r14, r12, LEVELS, 8 ; Load output levels
+    MOV r5, r14       ; r5 is GPIO level (0/1)
+    MOV r13, 0        ; r13 is underrun count
+BufLoop:
+    MOV r7, r2        ; r7 is buffer address
+    MOV r2, r3        ; Swap the buffers, taking the same time for each
+    MOV r3, r7
+WaitReady:
+    LBBO r8, r7, 0, 4 ; Load count
+    QBNE Ready, r8, 0
+    ADD r13, r13, 1   ; Buffer not ready
+    SBBO r13, r12, UNDERRUN, 4
+WaitLoop:
+    LBBO r8, r7, 0, 4
+    QBEQ WaitLoop, r8, 0
+Ready:
+    LSR r11, r8, 31   ; r11 is set on the last buffer
+    CLR r8, r8, 31    ; r8 is count of timings
+    ADD r9, r7, 4     ; r9 is data address
+SendLoop:
+    LBBO r10, r9, 0, 4 ; Load next pulse time
+    ADD r9, r9, 4     ; Increment address
+    SUB r10, r10, 10  ; Adjust time for the 11 cycles of the other instructions
+;
+; Test current bit, and set GPIO to 0 or 1
+;
+    QBBC SetOff, r5.b0, 0
+    SET r30, r30, r1  ; set GPIO output
+    QBA Delay
+SetOff:
+    CLR r30, r30, r1  ; clear GPIO output
+    QBA Delay         ; Same time as the set path
+;
+; Delay loop, 2 instructions.
+Delay:
+    SUB r10, r10, 2
+    QBNE Delay, r10, 0 ; Delay loop
+;
+; Flip bit
+;
+    XOR r5.b0, r5.b0, 1
+; Check length
+    SUB r8, r8, 1
+    QBNE SendLoop, r8, 0
+;
+; Buffer sent, leave GPIO at the idle level if it is the last.
+;
+    QBEQ Release, r11, 0
+    QBBS IdleHigh, r15, 0
+    CLR r30, r30, r1  ; clear GPIO output
+    QBA Release
+IdleHigh:
+    SET r30, r30, r1  ; set GPIO output
+Release:
+    SBBO r12, r7, 0, 4 ; Clear count
+    OR r31.b0, r0, 0x20
+    QBNE Finish, r11, 0
+    QBA BufLoop       ; Next buffer
+Finish:
+    HALT
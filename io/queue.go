@@ -0,0 +1,329 @@
+// Scheduling of transmissions sharing one transmitter.
+
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority of a queued transmission. Higher priority transmissions are
+// sent first, and transmissions of the same priority are sent in order.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+var errQueueClosed = errors.New("transmit queue closed")
+
+// Queue schedules the transmissions to the sinks of one transmitter.
+// Identical transmissions that are waiting to be sent are merged, and
+// the time spent transmitting over a rolling window can be limited,
+// as required in many bands (e.g. 10% per hour).
+type Queue struct {
+	duty    float64       // Maximum fraction of the window spent transmitting
+	window  time.Duration // Rolling window for the duty cycle
+	lock    sync.Mutex
+	pending []*queued
+	history []txSpan // Transmissions ending within the window
+	stats   QueueStats
+	wake    chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// QueueStats holds the statistics for a Queue.
+type QueueStats struct {
+	Queued    int           // Transmissions queued
+	Coalesced int           // Transmissions merged with an identical queued transmission
+	Sent      int           // Transmissions sent
+	Failed    int           // Transmissions that failed or were cancelled
+	Depth     int           // Transmissions waiting to be sent
+	MaxDepth  int           // Largest number of transmissions waiting
+	Wait      time.Duration // Total time transmissions waited to be sent
+	MaxWait   time.Duration // Longest time a transmission waited
+	Throttled time.Duration // Total time sending was delayed by the duty cycle limit
+	Busy      time.Duration // Time spent transmitting within the duty cycle window
+}
+
+// queued is a transmission waiting to be sent.
+type queued struct {
+	t    *Transmission
+	ctx  context.Context
+	sink Sink
+	seq  []Entry
+	pri  Priority
+	refs int           // Callers waiting for the transmission
+	est  time.Duration // Estimated transmission time
+	when time.Time     // Time queued
+}
+
+// txSpan is the time that a transmission ended, and how long it took.
+type txSpan struct {
+	end time.Time
+	d   time.Duration
+}
+
+// NewQueue creates a Queue. If duty is non-zero, the time spent transmitting
+// in any period of length window is limited to that fraction of the window.
+func NewQueue(duty float64, window time.Duration) *Queue {
+	q := &Queue{duty: duty, window: window, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+// Send queues a sequence of messages to be sent on the sink, which must be
+// the transmitter or one of its outputs. If an identical sequence is already
+// waiting to be sent on the sink, its Transmission is returned, and its
+// priority is raised if required. The transmission is cancelled once the
+// contexts of all the callers sharing it are done, or when it is cancelled
+// by one of them. A transmission cancelled before it is sent is removed
+// from the queue.
+func (q *Queue) Send(ctx context.Context, s Sink, seq []Entry, pri Priority) *Transmission {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, r := range q.pending {
+		if r.sink == s && r.ctx.Err() == nil && sameSequence(r.seq, seq) {
+			if pri > r.pri {
+				r.pri = pri
+			}
+			r.refs++
+			q.watch(ctx, r)
+			q.stats.Coalesced++
+			return r.t
+		}
+	}
+	t, tctx := newTransmission(context.Background())
+	r := &queued{t: t, ctx: tctx, sink: s, seq: seq, pri: pri, refs: 1, est: seqTime(s, seq), when: time.Now()}
+	select {
+	case <-q.stop:
+		t.finish(0, errQueueClosed)
+		return t
+	default:
+	}
+	if q.duty > 0 && r.est > time.Duration(q.duty*float64(q.window)) {
+		t.finish(0, fmt.Errorf("transmission (%s) exceeds duty cycle limit", r.est))
+		return t
+	}
+	q.pending = append(q.pending, r)
+	q.stats.Queued++
+	q.stats.Depth = len(q.pending)
+	if q.stats.Depth > q.stats.MaxDepth {
+		q.stats.MaxDepth = q.stats.Depth
+	}
+	q.watch(ctx, r)
+	context.AfterFunc(tctx, q.kick)
+	q.kick()
+	return t
+}
+
+// watch drops a reference to the transmission when the caller's context
+// is done, cancelling the transmission once no callers are waiting for it.
+func (q *Queue) watch(ctx context.Context, r *queued) {
+	context.AfterFunc(ctx, func() {
+		q.lock.Lock()
+		r.refs--
+		last := r.refs == 0
+		q.lock.Unlock()
+		if last {
+			r.t.Cancel()
+		}
+	})
+}
+
+// Stats returns the queue statistics.
+func (q *Queue) Stats() QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	st := q.stats
+	st.Busy = q.busy(time.Now())
+	return st
+}
+
+// Close stops the queue, waiting for any message being sent.
+// Transmissions still waiting are failed.
+func (q *Queue) Close() {
+	close(q.stop)
+	q.wg.Wait()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, r := range q.pending {
+		r.t.finish(0, errQueueClosed)
+	}
+	q.pending = nil
+	q.stats.Depth = 0
+}
+
+// kick wakes the queue to check the pending transmissions.
+func (q *Queue) kick() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) run() {
+	defer q.wg.Done()
+	for {
+		r, delay := q.next()
+		if r == nil || delay > 0 {
+			// Wait for a new transmission, or for the duty cycle to allow sending.
+			timer := time.NewTimer(delay)
+			if r == nil {
+				timer.Stop()
+			}
+			start := time.Now()
+			select {
+			case <-q.stop:
+				timer.Stop()
+				return
+			case <-q.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			if r != nil {
+				q.lock.Lock()
+				q.stats.Throttled += time.Since(start)
+				q.lock.Unlock()
+			}
+			continue
+		}
+		res := SendSequenceAsync(r.ctx, r.sink, r.seq).Wait()
+		q.lock.Lock()
+		if res.Duration > 0 {
+			q.history = append(q.history, txSpan{end: time.Now(), d: res.Duration})
+			q.busy(time.Now())
+		}
+		if res.Err != nil {
+			q.stats.Failed++
+		} else {
+			q.stats.Sent++
+		}
+		q.lock.Unlock()
+		r.t.finish(res.Duration, res.Err)
+	}
+}
+
+// next removes cancelled transmissions, and returns the transmission
+// to send next and how long to wait before sending it. The transmission
+// is removed from the queue if it can be sent now.
+func (q *Queue) next() (*queued, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	var best *queued
+	pending := q.pending[:0]
+	for _, r := range q.pending {
+		if err := r.ctx.Err(); err != nil {
+			q.stats.Failed++
+			r.t.finish(0, err)
+			continue
+		}
+		pending = append(pending, r)
+		if best == nil || r.pri > best.pri {
+			best = r
+		}
+	}
+	q.pending = pending
+	q.stats.Depth = len(q.pending)
+	if best == nil {
+		return nil, 0
+	}
+	if d := q.delay(now, best.est); d > 0 {
+		return best, d
+	}
+	for i, r := range q.pending {
+		if r == best {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			break
+		}
+	}
+	q.stats.Depth = len(q.pending)
+	wait := now.Sub(best.when)
+	q.stats.Wait += wait
+	if wait > q.stats.MaxWait {
+		q.stats.MaxWait = wait
+	}
+	return best, 0
+}
+
+// delay returns how long to wait before a transmission of length d
+// can be sent without exceeding the duty cycle limit.
+// The lock must be held.
+func (q *Queue) delay(now time.Time, d time.Duration) time.Duration {
+	if q.duty <= 0 {
+		return 0
+	}
+	excess := q.busy(now) + d - time.Duration(q.duty*float64(q.window))
+	// Find when enough of the earlier transmissions have left the window.
+	for _, s := range q.history {
+		if excess <= 0 {
+			break
+		}
+		excess -= s.d
+		if excess <= 0 {
+			return s.end.Add(q.window).Sub(now)
+		}
+	}
+	return 0
+}
+
+// busy returns the time spent transmitting within the window,
+// discarding the transmissions that have left it.
+// The lock must be held.
+func (q *Queue) busy(now time.Time) time.Duration {
+	start := now.Add(-q.window)
+	h := q.history[:0]
+	var t time.Duration
+	for _, s := range q.history {
+		if !s.end.After(start) {
+			continue
+		}
+		h = append(h, s)
+		t += s.d
+	}
+	q.history = h
+	return t
+}
+
+// seqTime returns the estimated time to send a sequence of messages.
+func seqTime(s Sink, seq []Entry) time.Duration {
+	var t time.Duration
+	for _, d := range sequence(seq, txGap(s)) {
+		t += d
+	}
+	return t
+}
+
+// txGap returns the inter-message gap used by the sink.
+func txGap(s Sink) int {
+	if g, ok := s.(interface{ gap() int }); ok {
+		return g.gap()
+	}
+	return defaultGap
+}
+
+// sameSequence returns true if the sequences are identical.
+func sameSequence(a, b []Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Repeats != b[i].Repeats || a[i].Gap != b[i].Gap || a[i].Carrier != b[i].Carrier || len(a[i].Msg) != len(b[i].Msg) {
+			return false
+		}
+		for j, t := range a[i].Msg {
+			if b[i].Msg[j] != t {
+				return false
+			}
+		}
+	}
+	return true
+}
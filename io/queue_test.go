@@ -0,0 +1,249 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink is a SequenceSink that records the sequences sent, taking
+// the length of the signal to send each one. If hold is set, each
+// sequence waits for hold to be closed before it is sent.
+type fakeSink struct {
+	hold    chan struct{}
+	started chan struct{} // Signalled as each sequence is started
+	lock    sync.Mutex
+	sent    [][]Entry
+}
+
+func newFakeSink(hold bool) *fakeSink {
+	s := &fakeSink{started: make(chan struct{}, 20)}
+	if hold {
+		s.hold = make(chan struct{})
+	}
+	return s
+}
+
+func (s *fakeSink) Send(msg []int, repeats int) error {
+	return s.SendSequence([]Entry{{Msg: Durations(msg), Repeats: repeats}})
+}
+
+func (s *fakeSink) SendSequence(seq []Entry) error {
+	s.started <- struct{}{}
+	if s.hold != nil {
+		<-s.hold
+	}
+	time.Sleep(seqTime(s, seq))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sent = append(s.sent, seq)
+	return nil
+}
+
+func (s *fakeSink) Close() {
+}
+
+// order returns the index in msgs of the first message of each sequence sent.
+func (s *fakeSink) order(msgs [][]Entry) []int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var o []int
+	for _, seq := range s.sent {
+		for i, m := range msgs {
+			if sameSequence(seq, m) {
+				o = append(o, i)
+			}
+		}
+	}
+	return o
+}
+
+// queueSeqs returns n distinct sequences of one short message.
+func queueSeqs(n int) [][]Entry {
+	var seqs [][]Entry
+	for i := 0; i < n; i++ {
+		d := time.Duration(i+1) * 100 * time.Microsecond
+		seqs = append(seqs, []Entry{{Msg: []time.Duration{d, d, d}, Repeats: 1}})
+	}
+	return seqs
+}
+
+func checkOrder(t *testing.T, got, want []int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, expected %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sent %v, expected %v", got, want)
+		}
+	}
+}
+
+// TestQueuePriority checks that the transmissions waiting are sent
+// in order of priority, and in the order queued within a priority.
+func TestQueuePriority(t *testing.T) {
+	s := newFakeSink(true)
+	q := NewQueue(0, 0)
+	defer q.Close()
+	seqs := queueSeqs(5)
+	ctx := context.Background()
+	var tr []*Transmission
+	tr = append(tr, q.Send(ctx, s, seqs[0], PriorityNormal))
+	// Queue the rest while the first is being sent.
+	<-s.started
+	for i, pri := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityNormal} {
+		tr = append(tr, q.Send(ctx, s, seqs[i+1], pri))
+	}
+	close(s.hold)
+	for i, x := range tr {
+		if res := x.Wait(); res.Err != nil {
+			t.Errorf("transmission %d: %v", i, res.Err)
+		}
+	}
+	checkOrder(t, s.order(seqs), []int{0, 3, 2, 4, 1})
+	if st := q.Stats(); st.Queued != 5 || st.Sent != 5 || st.Depth != 0 || st.MaxDepth != 4 {
+		t.Errorf("%d queued, %d sent, depth %d, max depth %d, expected 5, 5, 0, 4", st.Queued, st.Sent, st.Depth, st.MaxDepth)
+	}
+}
+
+// TestQueueCoalesce checks that identical transmissions waiting to be sent
+// are merged, taking the highest priority, and that a merged transmission
+// is only cancelled once all the callers sharing it have gone.
+func TestQueueCoalesce(t *testing.T) {
+	s := newFakeSink(true)
+	q := NewQueue(0, 0)
+	defer q.Close()
+	seqs := queueSeqs(3)
+	ctx := context.Background()
+	first := q.Send(ctx, s, seqs[0], PriorityNormal)
+	<-s.started
+	ctx1, cancel1 := context.WithCancel(ctx)
+	a := q.Send(ctx1, s, seqs[1], PriorityLow)
+	c := q.Send(ctx, s, seqs[2], PriorityHigh)
+	b := q.Send(ctx, s, seqs[1], PriorityHigh)
+	if a != b {
+		t.Errorf("identical transmissions not merged")
+	}
+	// The other caller is still waiting, so the transmission is not cancelled.
+	cancel1()
+	close(s.hold)
+	for i, x := range []*Transmission{first, b, c} {
+		if res := x.Wait(); res.Err != nil {
+			t.Errorf("transmission %d: %v", i, res.Err)
+		}
+	}
+	// The merged transmission was raised to high priority, and was queued first.
+	checkOrder(t, s.order(seqs), []int{0, 1, 2})
+	if st := q.Stats(); st.Queued != 3 || st.Coalesced != 1 || st.Sent != 3 {
+		t.Errorf("%d queued, %d coalesced, %d sent, expected 3, 1, 3", st.Queued, st.Coalesced, st.Sent)
+	}
+}
+
+// TestQueueCancel checks that a transmission cancelled while it is waiting
+// is removed from the queue without being sent.
+func TestQueueCancel(t *testing.T) {
+	s := newFakeSink(true)
+	q := NewQueue(0, 0)
+	defer q.Close()
+	seqs := queueSeqs(3)
+	ctx := context.Background()
+	first := q.Send(ctx, s, seqs[0], PriorityNormal)
+	<-s.started
+	ctx1, cancel1 := context.WithCancel(ctx)
+	a := q.Send(ctx1, s, seqs[1], PriorityHigh)
+	b := q.Send(ctx, s, seqs[2], PriorityNormal)
+	cancel1()
+	waitCancelled(q, 1)
+	close(s.hold)
+	if res := a.Wait(); !errors.Is(res.Err, context.Canceled) {
+		t.Errorf("cancelled transmission returned %v, expected %v", res.Err, context.Canceled)
+	}
+	for i, x := range []*Transmission{first, b} {
+		if res := x.Wait(); res.Err != nil {
+			t.Errorf("transmission %d: %v", i, res.Err)
+		}
+	}
+	checkOrder(t, s.order(seqs), []int{0, 2})
+	// Cancel a transmission using the Transmission.
+	s = newFakeSink(true)
+	first = q.Send(ctx, s, seqs[0], PriorityNormal)
+	<-s.started
+	a = q.Send(ctx, s, seqs[1], PriorityNormal)
+	a.Cancel()
+	close(s.hold)
+	first.Wait()
+	if res := a.Wait(); !errors.Is(res.Err, context.Canceled) {
+		t.Errorf("cancelled transmission returned %v, expected %v", res.Err, context.Canceled)
+	}
+	checkOrder(t, s.order(seqs), []int{0})
+	if st := q.Stats(); st.Sent != 3 || st.Failed != 2 {
+		t.Errorf("%d sent, %d failed, expected 3, 2", st.Sent, st.Failed)
+	}
+}
+
+// waitCancelled waits until n of the transmissions waiting have been cancelled,
+// since a caller's context being done is handled asynchronously.
+func waitCancelled(q *Queue, n int) {
+	for {
+		q.lock.Lock()
+		c := 0
+		for _, r := range q.pending {
+			if r.ctx.Err() != nil {
+				c++
+			}
+		}
+		q.lock.Unlock()
+		if c >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestQueueDuty checks that transmissions are delayed so that the time
+// spent transmitting within the window does not exceed the duty cycle.
+func TestQueueDuty(t *testing.T) {
+	s := newFakeSink(false)
+	seqs := queueSeqs(3)
+	est := seqTime(s, seqs[0])
+	const window = 300 * time.Millisecond
+	// Allow just under three transmissions in the window, so that the
+	// second is not delayed if the first takes longer than estimated.
+	q := NewQueue(2.9*float64(est)/float64(window), window)
+	defer q.Close()
+	ctx := context.Background()
+	start := time.Now()
+	var tr []*Transmission
+	for i := 0; i < 3; i++ {
+		tr = append(tr, q.Send(ctx, s, seqs[0], PriorityNormal))
+		if i < 2 {
+			// Allow it to start, so that the next is not merged with it.
+			<-s.started
+		}
+	}
+	var end []time.Duration
+	for i, x := range tr {
+		if res := x.Wait(); res.Err != nil {
+			t.Fatalf("transmission %d: %v", i, res.Err)
+		}
+		end = append(end, time.Since(start))
+	}
+	if end[1] > window/2 {
+		t.Errorf("second transmission delayed until %s", end[1])
+	}
+	// The third must wait for the first to leave the window.
+	if end[2] < window+est {
+		t.Errorf("third transmission completed at %s, expected at least %s", end[2], window+est)
+	}
+	if st := q.Stats(); st.Throttled == 0 || st.Sent != 3 {
+		t.Errorf("throttled for %s, %d sent, expected non-zero and 3", st.Throttled, st.Sent)
+	}
+	// A transmission longer than the duty cycle allows is rejected.
+	long := []Entry{{Msg: seqs[0][0].Msg, Repeats: 3}}
+	if res := q.Send(ctx, s, long, PriorityNormal).Wait(); res.Err == nil {
+		t.Errorf("transmission exceeding the duty cycle was sent")
+	}
+}
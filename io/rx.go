@@ -1,79 +1,114 @@
-// Module to read raw signals
+//go:build linux && arm
 
-//go:generate pasm -m prurx.p prurx
-//go:generate img2go.sh prurx io
+// Module to read raw signals
 
 package io
 
 import (
-	"encoding/binary"
 	"sync"
 	"time"
 
 	"github.com/aamcrae/pru"
 )
 
-const rx_event = 19
-const rxBufferSize = 128 // 512 bytes each buffer
-const bufCount = 8       // 4K of buffers
 const rx_unit = 1
 const rx_int = 3
 
+// A buffer ready value holds the buffer address, the number of words
+// stored in the buffer, a flag indicating the previous buffers were lost,
+// and a flag indicating that an odd number of edges was lost.
+const bufLost = 1 << 31
+const bufOdd = 1 << 30
+const bufCountShift = 16
+const bufAddrMask = 1<<bufCountShift - 1
+
 type Receiver struct {
+	BufCount int           // Number of receive buffers
+	BufSize  int           // Number of timings in each buffer
+	Flush    time.Duration // Time a partly filled buffer is held (0 to send only full buffers)
+	session  *Session
 	pru      *pru.PRU
 	gpio     uint32
 	bufReady chan uint32
-	send     chan time.Duration
+	pool     *bufPool
 	unit     *pru.Unit
 	event    *pru.Event
 	buffer   int
+	fw       *Firmware
+	running  bool
+	stopping sync.Mutex // Held while the receiver is stopped
+	lost     bool
+	lostOdd  bool   // An odd number of edges was lost
+	edges    *edges // Nil until the start time has been read
+	stats    RxStats
+	lock     sync.Mutex
 	wg       sync.WaitGroup
 }
 
+// NewReceiver returns a receiver using the PRU session shared
+// with NewTransmitter.
 func NewReceiver(gpio uint) (*Receiver, error) {
-	rx := new(Receiver)
-	rx.gpio = uint32(gpio)
-	pc := pru.NewConfig()
-	pc.EnableUnit(rx_unit).Event2Channel(rx_event, rx_int).Channel2Interrupt(rx_int, rx_int)
-	var err error
-	rx.pru, err = pru.Open(pc)
+	s, err := sharedSession()
 	if err != nil {
 		return nil, err
 	}
-	return rx, nil
+	defer s.Close()
+	return s.Receiver(gpio)
+}
+
+// SetFirmware sets the firmware run when the receiver is started.
+func (rx *Receiver) SetFirmware(fw *Firmware) error {
+	if err := fw.check(FirmwareRx, rxLayout); err != nil {
+		return err
+	}
+	rx.fw = fw
+	return nil
 }
 
+// Close the receiver, stopping it if it is running.
 func (rx *Receiver) Close() {
-	rx.pru.Close()
+	rx.Stop()
+	rx.session.closeReceiver(rx)
 }
 
 func (rx *Receiver) Start() (<-chan time.Duration, error) {
+	bc, err := rx.StartBatch()
+	if err != nil {
+		return nil, err
+	}
+	send := make(chan time.Duration, 200)
+	go unbatch(bc, send, rx.Release)
+	return send, nil
+}
+
+// StartBatch starts the receiver, delivering the timings from each
+// PRU buffer as a block. A buffer is delivered when it is full, or
+// once Flush has passed since its first timing. If timings were lost before a block,
+// the block starts with Dropped.
+func (rx *Receiver) StartBatch() (<-chan []time.Duration, error) {
 	rx.unit = rx.pru.Unit(rx_unit)
 	rx.event = rx.pru.Event(rx_event)
-	r := rx.unit.Ram.Open()
-	params := []interface{}{
-		uint32(rx_event - 16), // Event to send when buffer full
-		uint32(rx.gpio),       // GPIO to use
-		uint32(bufCount),      // Count of buffers
-		uint32(rxBufferSize),  // Buffer size
-		// ... buf addresses
-	}
-	for _, v := range params {
-		binary.Write(r, rx.pru.Order, v)
+	if err := rxCheck(rxParamSize, rxRecord, rx.BufCount, rx.BufSize, len(rx.unit.Ram)); err != nil {
+		return nil, err
 	}
-	bufStart := 0x100
+	rxParams(rx.unit.Ram, rx.pru.Order, rx.gpio, rx.BufCount, rx.BufSize, rxFlushTicks(rx.Flush, pru.MicroSeconds2Ticks))
 	rx.buffer = 0
-	for i := 0; i < bufCount; i++ {
-		binary.Write(r, rx.pru.Order, uint32(bufStart))
-		binary.Write(r, rx.pru.Order, uint32(0))
-		bufStart += rxBufferSize * 4
+	rx.lost = false
+	rx.lostOdd = false
+	rx.edges = nil
+	// Allow for the buffer being read and the buffer being filled.
+	rx.bufReady = make(chan uint32, rx.BufCount-2)
+	if rx.pool == nil || rx.pool.size != rx.BufSize {
+		rx.pool = newBufPool(rx.BufCount, rx.BufSize)
 	}
-	rx.bufReady = make(chan uint32, 10)
-	send := make(chan time.Duration, 200)
+	send := make(chan []time.Duration, 2)
+	rx.lock.Lock()
+	rx.running = true
+	rx.lock.Unlock()
 	rx.wg.Add(1)
 	rx.event.SetHandler(rx.fullBuffer)
 	go rx.readBuffer(send)
-	err := rx.unit.LoadAndRun(prurx_img)
+	err := rx.unit.LoadAndRun(rx.fw.Code)
 	if err != nil {
 		rx.Stop()
 		return nil, err
@@ -81,38 +116,76 @@ func (rx *Receiver) Start() (<-chan time.Duration, error) {
 	return send, nil
 }
 
+// Stop the receiver. Stopping a receiver that is not running does nothing,
+// and Stop returns once the receiver has stopped.
 func (rx *Receiver) Stop() {
+	rx.stopping.Lock()
+	defer rx.stopping.Unlock()
+	rx.lock.Lock()
+	running := rx.running
+	rx.running = false
+	rx.lock.Unlock()
+	if !running {
+		return
+	}
 	rx.unit.Disable()
 	rx.event.ClearHandler()
 	close(rx.bufReady)
 	rx.wg.Wait()
 }
 
+// Latency returns the longest time between an edge and the
+// delivery of the timing it ends.
+func (rx *Receiver) Latency() time.Duration {
+	return rxLatency(rx.Flush)
+}
+
+// Release returns a block received from StartBatch for reuse.
+func (rx *Receiver) Release(b []time.Duration) {
+	rx.pool.put(b)
+}
+
 // Read data from PRU
 func (rx *Receiver) Read(max int) ([]time.Duration, error) {
-	var tm []time.Duration
-	c, err := rx.Start()
-	if err != nil {
-		return nil, err
-	}
-	defer rx.Stop()
-	for i := 0; i < max; i++ {
-		tm = append(tm, <-c)
-	}
-	return tm, nil
+	return Capture(rx, max)
+}
+
+// Stats returns the receiver statistics.
+func (rx *Receiver) Stats() RxStats {
+	rx.lock.Lock()
+	defer rx.lock.Unlock()
+	return rx.stats
 }
 
-// Event handler
+// Event handler. If the reader has fallen behind, the buffer
+// is dropped, and the next buffer is flagged so that the reader
+// can indicate that timings were lost, and track the level of
+// the signal across the edges lost.
 func (rx *Receiver) fullBuffer() {
+	n := rxFilled(rx.unit.Ram, rx.pru.Order, rxParamSize, rx.buffer)
+	b := uint32(rxBufStart+rx.buffer*4*rx.BufSize) | uint32(n)<<bufCountShift
+	if rx.lost {
+		b |= bufLost
+		if rx.lostOdd {
+			b |= bufOdd
+		}
+	}
+	rx.lock.Lock()
 	select {
-	case rx.bufReady <- uint32(0x100 + rx.buffer*4*rxBufferSize):
+	case rx.bufReady <- b:
+		rx.lost = false
+		rx.lostOdd = false
+		rx.stats.Buffers++
 	default:
-		panic("Buffer overflow")
+		rx.lost = true
+		rx.lostOdd = rx.lostOdd != (n%2 != 0)
+		rx.stats.Overflows++
 	}
-	rx.buffer = (rx.buffer + 1) % bufCount
+	rx.lock.Unlock()
+	rx.buffer = (rx.buffer + 1) % rx.BufCount
 }
 
-func (rx *Receiver) readBuffer(send chan time.Duration) {
+func (rx *Receiver) readBuffer(send chan []time.Duration) {
 	for {
 		b := <-rx.bufReady
 		if b == 0 {
@@ -120,9 +193,18 @@ func (rx *Receiver) readBuffer(send chan time.Duration) {
 			rx.wg.Done()
 			return
 		}
-		for i := 0; i < rxBufferSize; i++ {
-			send <- pru.Duration(int(rx.pru.Order.Uint32(rx.unit.Ram[b:])))
-			b += 4
+		blk := rx.pool.get()
+		if rx.edges == nil {
+			rx.edges = newEdges(rx.pru.Order.Uint32(rx.unit.Ram[rxStart:]))
+		}
+		if b&bufLost != 0 {
+			blk = append(blk, Dropped)
+			rx.edges.lost(b&bufOdd != 0)
+			b &^= bufLost | bufOdd
 		}
+		blk = rx.edges.timings(blk, rx.unit.Ram[b&bufAddrMask:], rx.pru.Order, int(b>>bufCountShift), func(t uint32) time.Duration {
+			return pru.Duration(int(t))
+		})
+		send <- blk
 	}
 }
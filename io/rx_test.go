@@ -0,0 +1,224 @@
+package io
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// rxInput returns n timings alternating between 30us and 70us.
+func rxInput(n int) []time.Duration {
+	in := make([]time.Duration, n)
+	for i := range in {
+		in[i] = 30 * time.Microsecond
+		if i%2 != 0 {
+			in[i] = 70 * time.Microsecond
+		}
+	}
+	return in
+}
+
+// near returns true if the timing is within a microsecond of the expected timing.
+func near(d, want time.Duration) bool {
+	return d > want-time.Microsecond && d < want+time.Microsecond
+}
+
+// TestEmuReceiverFlush checks that the timings in a partly filled buffer
+// are delivered once the flush time has passed, and that only full
+// buffers are delivered when flushing is disabled.
+func TestEmuReceiverFlush(t *testing.T) {
+	in := rxInput(2*rxBufferSize + 21)
+	for _, tc := range []struct {
+		flush time.Duration
+		want  int
+	}{
+		{rxFlush, len(in)},
+		{0, 2 * rxBufferSize},
+	} {
+		rx := NewEmuReceiver(0, in)
+		rx.Flush = tc.flush
+		c, err := rx.Start()
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		var got []time.Duration
+		for d := range c {
+			got = append(got, d)
+		}
+		if len(got) != tc.want {
+			t.Errorf("flush %s: %d timings received, expected %d", tc.flush, len(got), tc.want)
+			continue
+		}
+		for i, d := range got {
+			if !near(d, in[i]) {
+				t.Errorf("flush %s: timing %d is %s, expected %s", tc.flush, i, d, in[i])
+				break
+			}
+		}
+		if st := rx.Stats(); tc.flush == 0 && st.Buffers != 2 {
+			t.Errorf("flush %s: %d buffers, expected 2", tc.flush, st.Buffers)
+		}
+	}
+}
+
+// TestEmuMultiReceiverFlush checks that each channel of the multi-input
+// receiver delivers the timings in a partly filled buffer.
+func TestEmuMultiReceiverFlush(t *testing.T) {
+	inputs := [][]time.Duration{rxInput(31), rxInput(11)}
+	rx := NewEmuMultiReceiver([]uint{2, 5}, inputs)
+	c, err := rx.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	got := make([][]time.Duration, len(inputs))
+	for tm := range c {
+		got[tm.Channel] = append(got[tm.Channel], tm.Duration)
+	}
+	for ch, in := range inputs {
+		if len(got[ch]) != len(in) {
+			t.Errorf("channel %d: %d timings received, expected %d", ch, len(got[ch]), len(in))
+			continue
+		}
+		for i, d := range got[ch] {
+			if !near(d, in[i]) {
+				t.Errorf("channel %d: timing %d is %s, expected %s", ch, i, d, in[i])
+				break
+			}
+		}
+	}
+}
+
+// TestEdgesDropped checks that when buffers holding an odd number of edges
+// are lost, the timings after each loss restart with a low period, and do
+// not include the time lost.
+func TestEdgesDropped(t *testing.T) {
+	const start = 1000
+	var in []time.Duration
+	var ts []uint32
+	now := uint32(start)
+	for i := 0; i < 40; i++ {
+		d := uint32(10 * (i + 1))
+		now += d
+		in = append(in, time.Duration(d)*time.Microsecond)
+		ts = append(ts, now)
+	}
+	ticks := func(t uint32) time.Duration {
+		return time.Duration(t) * time.Microsecond
+	}
+	// The buffers of 5 and 7 edges are lost.
+	sizes := []int{8, 5, 6, 7, 14}
+	lost := []bool{false, true, false, true, false}
+	e := newEdges(start)
+	buf := make([]byte, 4*len(ts))
+	var got []time.Duration
+	edge := 0
+	for i, n := range sizes {
+		if lost[i] {
+			got = append(got, Dropped)
+			e.lost(n%2 != 0)
+		} else {
+			for j := 0; j < n; j++ {
+				binary.LittleEndian.PutUint32(buf[j*4:], ts[edge+j])
+			}
+			got = e.timings(got, buf, binary.LittleEndian, n, ticks)
+		}
+		edge += n
+	}
+	// After the first loss, the next edge is falling, so only its
+	// timing is dropped. After the second, the next edge is rising,
+	// so the timings up to the following falling edge are dropped.
+	var want []time.Duration
+	want = append(want, in[0:8]...)
+	want = append(want, Dropped)
+	want = append(want, in[14:19]...)
+	want = append(want, Dropped)
+	want = append(want, in[28:40]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("timings %v, expected %v", got, want)
+	}
+}
+
+// TestTimerWrap checks that the intervals between IEP timer timestamps
+// are correct when the timer wraps, for both receivers.
+func TestTimerWrap(t *testing.T) {
+	ticks := func(t uint32) time.Duration {
+		return time.Duration(t) * 5 * time.Nanosecond
+	}
+	const start = 0xFFFFFF00
+	intervals := []uint32{100, 150, 6, 200, 0xFFFF, 300}
+	var want []time.Duration
+	var ts []uint32
+	now := uint32(start)
+	for _, v := range intervals {
+		now += v
+		ts = append(ts, now)
+		want = append(want, ticks(v))
+	}
+	// Single input receiver.
+	buf := make([]byte, 4*len(ts))
+	for i, v := range ts {
+		binary.LittleEndian.PutUint32(buf[i*4:], v)
+	}
+	got := newEdges(start).timings(nil, buf, binary.LittleEndian, len(ts), ticks)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("timings %v, expected %v", got, want)
+	}
+	// Multi-input receiver, with the timestamps on channel 1, and
+	// channel 0 changing once before the timer wraps.
+	gpios := []uint{2, 7}
+	rec := make([]byte, 8*(len(ts)+1))
+	binary.LittleEndian.PutUint32(rec[0:], start+10)
+	binary.LittleEndian.PutUint32(rec[4:], 1<<2)
+	bits := uint32(1 << 2)
+	for i, v := range ts {
+		bits ^= 1 << 7
+		binary.LittleEndian.PutUint32(rec[8*i+8:], v)
+		binary.LittleEndian.PutUint32(rec[8*i+12:], bits)
+	}
+	out := newDemux(gpios, start, 0).records(nil, rec, binary.LittleEndian, 2*(len(ts)+1), ticks)
+	wantm := []Timing{{0, ticks(10)}}
+	for _, d := range want {
+		wantm = append(wantm, Timing{1, d})
+	}
+	if !reflect.DeepEqual(out, wantm) {
+		t.Errorf("multi-input timings %v, expected %v", out, wantm)
+	}
+}
+
+// TestStop checks that sources can be stopped without being started,
+// and stopped more than once.
+func TestStop(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		new  func() Source
+	}{
+		{"memory", func() Source { return NewMemorySource(rxInput(10)) }},
+		{"ether", func() Source { return NewEther(1).Receiver() }},
+		{"emulated", func() Source { return NewEmuReceiver(0, rxInput(10)) }},
+	} {
+		tc.new().Stop()
+		src := tc.new()
+		c, err := src.Start()
+		if err != nil {
+			t.Fatalf("%s: Start: %v", tc.name, err)
+		}
+		src.Stop()
+		src.Stop()
+		for range c {
+		}
+		// The source can be started again after being stopped.
+		if _, err := src.Start(); err != nil {
+			t.Fatalf("%s: Start after Stop: %v", tc.name, err)
+		}
+		src.Stop()
+		src.Close()
+	}
+	m := NewEmuMultiReceiver([]uint{2, 5}, [][]time.Duration{rxInput(10), rxInput(10)})
+	m.Stop()
+	if _, err := m.Start(); err != nil {
+		t.Fatalf("multi-input: Start: %v", err)
+	}
+	m.Stop()
+	m.Stop()
+}
@@ -0,0 +1,169 @@
+//go:build linux && arm
+
+// Module to read raw signals from several inputs
+
+package io
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aamcrae/pru"
+)
+
+// MultiReceiver monitors several inputs using one PRU unit.
+type MultiReceiver struct {
+	BufCount int           // Number of receive buffers
+	BufSize  int           // Number of words in each buffer
+	Flush    time.Duration // Time a partly filled buffer is held (0 to send only full buffers)
+	session  *Session
+	pru      *pru.PRU
+	gpios    []uint
+	bufReady chan uint32
+	unit     *pru.Unit
+	event    *pru.Event
+	buffer   int
+	fw       *Firmware
+	demux    *demux
+	running  bool
+	stopping sync.Mutex // Held while the receiver is stopped
+	lost     bool
+	stats    RxStats
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewMultiReceiver returns a multi-input receiver using the PRU session
+// shared with NewTransmitter. It uses the same PRU unit as NewReceiver.
+func NewMultiReceiver(gpios []uint) (*MultiReceiver, error) {
+	if err := multiCheck(gpios); err != nil {
+		return nil, err
+	}
+	s, err := sharedSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.MultiReceiver(gpios)
+}
+
+// SetFirmware sets the firmware run when the receiver is started.
+func (rx *MultiReceiver) SetFirmware(fw *Firmware) error {
+	if err := fw.check(FirmwareRxMulti, rxmLayout); err != nil {
+		return err
+	}
+	rx.fw = fw
+	return nil
+}
+
+// Close the receiver, stopping it if it is running.
+func (rx *MultiReceiver) Close() {
+	rx.Stop()
+	rx.session.closeReceiver(rx)
+}
+
+// Start the receiver. If timings were lost, Dropped is
+// sent for every channel.
+func (rx *MultiReceiver) Start() (<-chan Timing, error) {
+	rx.unit = rx.pru.Unit(rx_unit)
+	rx.event = rx.pru.Event(rx_event)
+	if err := rxCheck(rxmParamSize, rxmRecord, rx.BufCount, rx.BufSize, len(rx.unit.Ram)); err != nil {
+		return nil, err
+	}
+	rxmParams(rx.unit.Ram, rx.pru.Order, rx.gpios, rx.BufCount, rx.BufSize, rxFlushTicks(rx.Flush, pru.MicroSeconds2Ticks))
+	rx.buffer = 0
+	rx.lost = false
+	rx.demux = nil
+	// Allow for the buffer being read and the buffer being filled.
+	rx.bufReady = make(chan uint32, rx.BufCount-2)
+	send := make(chan Timing, 200)
+	rx.lock.Lock()
+	rx.running = true
+	rx.lock.Unlock()
+	rx.wg.Add(1)
+	rx.event.SetHandler(rx.fullBuffer)
+	go rx.readBuffer(send)
+	err := rx.unit.LoadAndRun(rx.fw.Code)
+	if err != nil {
+		rx.Stop()
+		return nil, err
+	}
+	return send, nil
+}
+
+// Stop the receiver, as for Receiver.
+func (rx *MultiReceiver) Stop() {
+	rx.stopping.Lock()
+	defer rx.stopping.Unlock()
+	rx.lock.Lock()
+	running := rx.running
+	rx.running = false
+	rx.lock.Unlock()
+	if !running {
+		return
+	}
+	rx.unit.Disable()
+	rx.event.ClearHandler()
+	close(rx.bufReady)
+	rx.wg.Wait()
+}
+
+// Latency returns the longest time between an edge and the
+// delivery of the timing it ends.
+func (rx *MultiReceiver) Latency() time.Duration {
+	return rxLatency(rx.Flush)
+}
+
+// Stats returns the receiver statistics.
+func (rx *MultiReceiver) Stats() RxStats {
+	rx.lock.Lock()
+	defer rx.lock.Unlock()
+	return rx.stats
+}
+
+// Event handler, as for Receiver.
+func (rx *MultiReceiver) fullBuffer() {
+	n := rxFilled(rx.unit.Ram, rx.pru.Order, rxmParamSize, rx.buffer)
+	b := uint32(rxBufStart+rx.buffer*4*rx.BufSize) | uint32(n)<<bufCountShift
+	if rx.lost {
+		b |= bufLost
+	}
+	rx.lock.Lock()
+	select {
+	case rx.bufReady <- b:
+		rx.lost = false
+		rx.stats.Buffers++
+	default:
+		rx.lost = true
+		rx.stats.Overflows++
+	}
+	rx.lock.Unlock()
+	rx.buffer = (rx.buffer + 1) % rx.BufCount
+}
+
+func (rx *MultiReceiver) readBuffer(send chan Timing) {
+	var tm []Timing
+	for {
+		b := <-rx.bufReady
+		if b == 0 {
+			close(send)
+			rx.wg.Done()
+			return
+		}
+		ram := rx.unit.Ram
+		if rx.demux == nil {
+			rx.demux = newDemux(rx.gpios, rx.pru.Order.Uint32(ram[rxmStart:]), rx.pru.Order.Uint32(ram[rxmStart+4:]))
+		}
+		tm = tm[:0]
+		if b&bufLost != 0 {
+			tm = rx.demux.dropped(tm)
+			b &^= bufLost
+		}
+		tm = rx.demux.records(tm, ram[b&bufAddrMask:], rx.pru.Order, int(b>>bufCountShift), func(t uint32) time.Duration {
+			return pru.Duration(int(t))
+		})
+		for _, t := range tm {
+			send <- t
+		}
+	}
+}
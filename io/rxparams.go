@@ -0,0 +1,165 @@
+// Parameters and buffers of the receiver PRU firmware.
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Default time a partly filled buffer is held by the firmware before
+// it is sent, so that timings are not held back while the signal is idle.
+// While the signal is active, a buffer is sent at least this often, so
+// a shorter time leaves less time for the buffers to be read.
+const rxFlush = 5 * time.Millisecond
+
+// Time allowed for the host to read a buffer once the firmware has sent it.
+const rxReadDelay = 5 * time.Millisecond
+
+// RxStats holds the statistics for a receiver.
+type RxStats struct {
+	Buffers   int // Buffers received
+	Overflows int // Buffers lost because they were not read in time
+}
+
+// rxCheck verifies that the receive buffers fit in the unit RAM,
+// after a parameter block of paramSize bytes. The firmware stores
+// records of record words, and only checks for a full buffer after
+// each record, so the buffer size must be a multiple of the record size.
+func rxCheck(paramSize, record, count, size, ramSize int) error {
+	if count < 3 || paramSize+count*8 > rxBufStart {
+		return fmt.Errorf("receive buffer count %d out of range (3-%d)", count, (rxBufStart-paramSize)/8)
+	}
+	if size < record || size%record != 0 {
+		return fmt.Errorf("receive buffer size %d must be a multiple of %d", size, record)
+	}
+	if rxBufStart+count*size*4 > ramSize {
+		return fmt.Errorf("receive buffers (%d x %d) do not fit in PRU RAM", count, size)
+	}
+	return nil
+}
+
+// rxFlushTicks converts the flush time to IEP timer ticks, using ticks
+// to convert microseconds to ticks. If flush is 0, only full buffers are sent.
+func rxFlushTicks(flush time.Duration, ticks func(int) int) uint32 {
+	if flush <= 0 {
+		return ^uint32(0)
+	}
+	return uint32(ticks(int(flush / time.Microsecond)))
+}
+
+// rxLatency returns the longest time between an edge and the delivery
+// of its timing by a receiver that flushes partly filled buffers.
+func rxLatency(flush time.Duration) time.Duration {
+	if flush <= 0 {
+		// Timings are only delivered when a buffer is full.
+		return 0
+	}
+	return flush + rxReadDelay
+}
+
+// rxParams writes the receiver firmware parameters to the unit RAM.
+// flush is the number of IEP timer ticks a partly filled buffer is held.
+func rxParams(ram []byte, order binary.ByteOrder, gpio uint32, count, size int, flush uint32) {
+	params := []uint32{
+		uint32(rx_event - 16), // Event to send when buffer full
+		gpio,                  // GPIO to use
+		uint32(count),         // Count of buffers
+		uint32(size),          // Buffer size
+		flush,                 // Ticks before a partly filled buffer is sent
+		0,                     // Start time, written by firmware
+		// ... buf addresses
+	}
+	rxBuffers(ram, order, params, count, size)
+}
+
+// rxBuffers writes the receiver parameters, followed by the list of
+// buffer addresses. Each address is followed by the end of the data in
+// the buffer, which is written by the firmware when the buffer is sent.
+func rxBuffers(ram []byte, order binary.ByteOrder, params []uint32, count, size int) {
+	for i, v := range params {
+		order.PutUint32(ram[i*4:], v)
+	}
+	a := len(params) * 4
+	bufStart := rxBufStart
+	for i := 0; i < count; i++ {
+		order.PutUint32(ram[a:], uint32(bufStart))
+		order.PutUint32(ram[a+4:], 0)
+		a += 8
+		bufStart += size * 4
+	}
+}
+
+// rxFilled returns the number of words the firmware stored in a buffer,
+// from the end of the data stored in the list of buffer addresses.
+func rxFilled(ram []byte, order binary.ByteOrder, paramSize, buffer int) int {
+	a := paramSize + buffer*8
+	return int(order.Uint32(ram[a+4:])-order.Uint32(ram[a:])) / 4
+}
+
+// edges converts the edge timestamps stored by the receiver firmware into
+// timings. The firmware stores only the time of each edge, so the level of
+// each period is tracked from the number of edges, including those in
+// buffers that were lost. After timings are lost, the first timing is
+// dropped, since it covers the lost period, and the timings are ignored
+// until a falling edge, so that the timings start with a low period.
+type edges struct {
+	last  uint32 // Time of the last edge
+	level int    // Level of the period ended by the next edge
+	sync  bool   // Waiting for a falling edge
+}
+
+// newEdges returns an edges for a receiver started at start,
+// which the firmware records once the input is low.
+func newEdges(start uint32) *edges {
+	return &edges{last: start}
+}
+
+// lost records that timings were lost, and whether an odd
+// number of edges was lost.
+func (e *edges) lost(odd bool) {
+	if odd {
+		e.level ^= 1
+	}
+	e.sync = true
+}
+
+// timings appends the intervals between the n edge timestamps in a
+// receive buffer, using ticks to convert IEP timer ticks to a duration.
+// The timer wraps, so intervals are calculated modulo 32 bits.
+func (e *edges) timings(blk []time.Duration, buf []byte, order binary.ByteOrder, n int, ticks func(uint32) time.Duration) []time.Duration {
+	for i := 0; i < n; i++ {
+		ts := order.Uint32(buf[i*4:])
+		lv := e.level
+		e.level ^= 1
+		if e.sync {
+			if lv == 1 {
+				e.sync = false
+			}
+		} else {
+			blk = append(blk, ticks(ts-e.last))
+		}
+		e.last = ts
+	}
+	return blk
+}
+
+// rxmParams writes the multi-input receiver firmware parameters to the unit RAM.
+func rxmParams(ram []byte, order binary.ByteOrder, gpios []uint, count, size int, flush uint32) {
+	var mask uint32
+	for _, g := range gpios {
+		mask |= 1 << g
+	}
+	params := []uint32{
+		uint32(rx_event - 16), // Event to send when buffer full
+		mask,                  // GPIO bits to monitor
+		uint32(count),         // Count of buffers
+		uint32(size),          // Buffer size
+		flush,                 // Ticks before a partly filled buffer is sent
+		0,                     // Start time, written by firmware
+		0,                     // Input bits at start, written by firmware
+		// ... buf addresses
+	}
+	rxBuffers(ram, order, params, count, size)
+}
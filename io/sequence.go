@@ -0,0 +1,129 @@
+// Sending a sequence of messages in one transmission.
+
+package io
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Entry is one message in a sequence.
+type Entry struct {
+	Msg     []time.Duration
+	Repeats int
+	Gap     time.Duration // Time the output is held low after the message
+	Carrier Carrier       // Modulation of the message
+}
+
+// SequenceSink is a Sink that can send a sequence of messages as
+// one transmission, so that the gaps between the messages are exact.
+type SequenceSink interface {
+	Sink
+	SendSequence(seq []Entry) error
+}
+
+// SendSequence sends a sequence of messages. If the Sink does not support
+// sequences, each message is sent in turn, followed by a sleep for the gap.
+func SendSequence(s Sink, seq []Entry) error {
+	if ss, ok := s.(SequenceSink); ok {
+		return ss.SendSequence(seq)
+	}
+	for _, e := range seq {
+		if e.Carrier.Freq != 0 {
+			return fmt.Errorf("carrier modulation not supported by the transmitter")
+		}
+		if err := SendPrecise(s, e.Msg, e.Repeats); err != nil {
+			return err
+		}
+		time.Sleep(e.Gap)
+	}
+	return nil
+}
+
+// txMessage returns the signal for one repeat of a message. As with the
+// transmitter firmware, it starts with a low, high and low period of the
+// inter-message gap (in microseconds).
+func txMessage(msg []time.Duration, gap int) []time.Duration {
+	g := time.Duration(gap) * time.Microsecond
+	return append([]time.Duration{g, g, g}, msg...)
+}
+
+// sequence returns the signal for a sequence of messages, starting with
+// the output low.
+func sequence(seq []Entry, gap int) []time.Duration {
+	var out []time.Duration
+	for _, e := range seq {
+		m := txMessage(e.Msg, gap)
+		if e.Carrier.Freq != 0 {
+			// Hold the output low for the whole of the gap
+			// before the message, rather than sending a carrier burst.
+			m = append([]time.Duration{m[0] + m[1] + m[2]}, m[3:]...)
+		}
+		for r := 0; r < e.Repeats; r++ {
+			out = appendSignal(out, m)
+		}
+		if e.Gap > 0 {
+			out = appendSignal(out, []time.Duration{e.Gap})
+		}
+	}
+	return out
+}
+
+// repeatSignal returns the signal repeated, as the transmitter firmware sends it.
+func repeatSignal(signal []time.Duration, repeats int) []time.Duration {
+	var out []time.Duration
+	for r := 0; r < repeats; r++ {
+		out = appendSignal(out, signal)
+	}
+	return out
+}
+
+// appendSignal appends a signal that starts with the output low.
+// Adjacent periods of the same level are merged.
+func appendSignal(out, signal []time.Duration) []time.Duration {
+	for i, t := range signal {
+		if len(out)%2 != i%2 {
+			out[len(out)-1] += t
+		} else {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// sendSequence sends a sequence of messages on the gpio output of a
+// transmitter, using one transmission for each run of messages with
+// the same carrier. The total time taken is returned.
+// A run of several messages is sent with the repeats expanded, so it must
+// fit in the PRU RAM unless the transmitter streams long signals. A run of
+// one message is repeated by the firmware, so only one repeat must fit,
+// and the gap after it is sent as a separate transmission.
+func sendSequence(ctx context.Context, tx transmitter, gpio uint32, seq []Entry) (time.Duration, error) {
+	var total time.Duration
+	for len(seq) > 0 {
+		n := 1
+		for n < len(seq) && seq[n].Carrier == seq[0].Carrier {
+			n++
+		}
+		var d time.Duration
+		var err error
+		if e := seq[0]; n == 1 && e.Repeats > 0 {
+			one := []Entry{{Msg: e.Msg, Repeats: 1, Carrier: e.Carrier}}
+			d, err = tx.transmit(ctx, gpio, sequence(one, tx.gap()), e.Repeats, e.Carrier)
+			if err == nil && e.Gap > 0 {
+				var g time.Duration
+				g, err = tx.transmit(ctx, gpio, []time.Duration{e.Gap}, 1, Carrier{})
+				d += g
+			}
+		} else {
+			d, err = tx.transmit(ctx, gpio, sequence(seq[:n], tx.gap()), 1, seq[0].Carrier)
+		}
+		total += d
+		if err != nil {
+			return total, err
+		}
+		seq = seq[n:]
+	}
+	return total, nil
+}
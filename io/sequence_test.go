@@ -0,0 +1,72 @@
+package io
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/emu"
+)
+
+// seqMsg returns a message of n timings alternating between 50us and 100us.
+func seqMsg(n int) []time.Duration {
+	msg := make([]time.Duration, n)
+	for i := range msg {
+		msg[i] = 50 * time.Microsecond
+		if i%2 != 0 {
+			msg[i] = 100 * time.Microsecond
+		}
+	}
+	return msg
+}
+
+// checkSignal compares the signal sent by an emulated transmitter with
+// the expected signal. The signal may end with the time from the last
+// change of the output until the firmware halted.
+func checkSignal(t *testing.T, name string, tx *EmuTransmitter, want []time.Duration) {
+	t.Helper()
+	got := tx.Signal()
+	if len(got) == len(want)+1 && len(want)%2 == 0 {
+		got = got[:len(want)]
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d periods sent, expected %d", name, len(got), len(want))
+	}
+	for i, c := range got {
+		if d := emu.Duration(c); !near(d, want[i]) {
+			t.Errorf("%s: period %d is %s, expected %s", name, i, d, want[i])
+			return
+		}
+	}
+}
+
+// TestSequenceRepeats checks that a single message is repeated by the
+// firmware, so that it can be sent when only one repeat fits in the PRU RAM.
+func TestSequenceRepeats(t *testing.T) {
+	tx := NewEmuTransmitter(0)
+	msg := seqMsg(txMax(len(tx.PRU.Ram))/2 | 1)
+	const repeats = 3
+	if err := tx.SendSequence([]Entry{{Msg: msg, Repeats: repeats}}); err != nil {
+		t.Fatalf("SendSequence: %v", err)
+	}
+	checkSignal(t, "repeats", tx, repeatSignal(txMessage(msg, tx.Gap), repeats))
+	// The gap after the message is sent separately.
+	gap := 20 * time.Millisecond
+	if err := tx.SendSequence([]Entry{{Msg: msg, Repeats: repeats, Gap: gap}}); err != nil {
+		t.Fatalf("SendSequence with gap: %v", err)
+	}
+	st := tx.Stats()
+	if st.Sent != 3 || st.Last < gap-time.Microsecond || st.Last > gap+time.Microsecond {
+		t.Errorf("%d transmissions, last took %s, expected 3 and %s", st.Sent, st.Last, gap)
+	}
+	// Several messages are sent in one transmission, with the repeats expanded.
+	seq := []Entry{{Msg: msg[:5], Repeats: 2, Gap: gap}, {Msg: msg[:7], Repeats: 1}}
+	if err := tx.SendSequence(seq); err != nil {
+		t.Fatalf("SendSequence of 2 messages: %v", err)
+	}
+	checkSignal(t, "sequence", tx, sequence(seq, tx.Gap))
+	// Several messages that only fit in the PRU RAM once are too long.
+	seq = []Entry{{Msg: msg, Repeats: 2}, {Msg: msg[:5], Repeats: 1}}
+	if err := tx.SendSequence(seq); err == nil {
+		t.Errorf("sequence too long for PRU RAM sent without error")
+	}
+}
@@ -0,0 +1,170 @@
+//go:build linux && arm
+
+// Shared PRU session for receiving and transmitting.
+
+package io
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aamcrae/pru"
+)
+
+// Session is a PRU session configured for both the receiver and
+// transmitter units, so that one process can receive and transmit
+// at the same time. The PRU is closed once the session and the
+// receiver and transmitter obtained from it have all been closed.
+type Session struct {
+	pru  *pru.PRU
+	lock sync.Mutex
+	refs int
+	rx   interface{} // Receiver or MultiReceiver
+	tx   *Transmitter
+}
+
+var errClosed = errors.New("PRU session is closed")
+
+// The session shared by NewReceiver and NewTransmitter.
+var sharedLock sync.Mutex
+var shared *Session
+
+func OpenSession() (*Session, error) {
+	pc := pru.NewConfig()
+	pc.EnableUnit(rx_unit).Event2Channel(rx_event, rx_int).Channel2Interrupt(rx_int, rx_int)
+	pc.EnableUnit(tx_unit).Event2Channel(tx_event, tx_int).Channel2Interrupt(tx_int, tx_int)
+	p, err := pru.Open(pc)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{pru: p, refs: 1}, nil
+}
+
+// sharedSession returns the shared session, opening it if required.
+// The caller must close the session when finished with it.
+func sharedSession() (*Session, error) {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	if shared != nil {
+		shared.lock.Lock()
+		defer shared.lock.Unlock()
+		if shared.refs > 0 {
+			shared.refs++
+			return shared, nil
+		}
+	}
+	var err error
+	shared, err = OpenSession()
+	return shared, err
+}
+
+// Receiver returns the receiver for the session, using the gpio bit as input.
+// Only one receiver may be in use at a time.
+func (s *Session) Receiver(gpio uint) (*Receiver, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.refs == 0 {
+		return nil, errClosed
+	}
+	if s.rx != nil {
+		return nil, errors.New("PRU receiver already in use")
+	}
+	rx := new(Receiver)
+	rx.session = s
+	rx.pru = s.pru
+	rx.gpio = uint32(gpio)
+	rx.fw = rxFirmware
+	rx.BufCount = bufCount
+	rx.BufSize = rxBufferSize
+	rx.Flush = rxFlush
+	s.rx = rx
+	s.refs++
+	return rx, nil
+}
+
+// MultiReceiver returns a receiver for the session monitoring
+// several gpio inputs. It uses the same PRU unit as Receiver,
+// so only one of them may be in use at a time.
+func (s *Session) MultiReceiver(gpios []uint) (*MultiReceiver, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.refs == 0 {
+		return nil, errClosed
+	}
+	if s.rx != nil {
+		return nil, errors.New("PRU receiver already in use")
+	}
+	rx := new(MultiReceiver)
+	rx.session = s
+	rx.pru = s.pru
+	rx.gpios = append([]uint(nil), gpios...)
+	rx.fw = rxmFirmware
+	rx.BufCount = bufCount
+	rx.BufSize = rxBufferSize
+	rx.Flush = rxFlush
+	s.rx = rx
+	s.refs++
+	return rx, nil
+}
+
+// Transmitter returns the transmitter for the session, using the gpio bit as output.
+// Only one transmitter may be in use at a time.
+func (s *Session) Transmitter(gpio uint) (*Transmitter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.refs == 0 {
+		return nil, errClosed
+	}
+	if s.tx != nil {
+		return nil, errors.New("PRU transmitter already in use")
+	}
+	tx := new(Transmitter)
+	tx.session = s
+	tx.pru = s.pru
+	tx.Gap = defaultGap
+	tx.gpio = uint32(gpio)
+	tx.fw = txFirmware
+	tx.sfw = txsFirmware
+	tx.busy = make(chan struct{}, 1)
+	s.tx = tx
+	s.refs++
+	return tx, nil
+}
+
+// Close the session. The PRU remains open until the receiver
+// and transmitter have also been closed.
+func (s *Session) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.release()
+}
+
+// release drops a reference to the session, closing the PRU
+// when there are none left. The lock must be held.
+func (s *Session) release() {
+	if s.refs == 0 {
+		return
+	}
+	s.refs--
+	if s.refs == 0 {
+		s.pru.Close()
+	}
+}
+
+func (s *Session) closeReceiver(rx interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.rx == rx {
+		s.rx = nil
+		s.release()
+	}
+}
+
+func (s *Session) closeTransmitter(tx *Transmitter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == tx {
+		s.tx = nil
+		s.release()
+	}
+}
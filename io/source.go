@@ -0,0 +1,162 @@
+// Hardware independent interfaces for receiving and transmitting.
+
+package io
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultGap = 5000
+
+// Dropped is sent in place of timings that a Source has lost,
+// such as when the receiver buffers overflow.
+const Dropped = -time.Microsecond
+
+// Source is a stream of timings between the edges of a received signal.
+// The first timing is the length of time the signal is low, the next
+// is how long it is high, and so on.
+// The channel returned by Start is closed when the Source is stopped.
+// If timings are lost, Dropped is sent in their place.
+type Source interface {
+	Start() (<-chan time.Duration, error)
+	Stop()
+	Close()
+}
+
+// Sink transmits messages. A message is a list of microsecond timings,
+// with the first timing being how long the output is held high, then low, and so on.
+type Sink interface {
+	Send(msg []int, repeats int) error
+	Close()
+}
+
+// PreciseSink is a Sink that can transmit timings with
+// a resolution finer than a microsecond.
+type PreciseSink interface {
+	Sink
+	SendPrecise(msg []time.Duration, repeats int) error
+}
+
+// OpenSource creates a Source from a backend specification:
+//
+//	pru          - PRU based receiver using the gpio bit.
+//	file:<name>  - timings (in microseconds) read from a file.
+//	sim          - receiver attached to the DefaultEther simulated medium
+//	               (shared only within the process).
+//	emu:<name>   - emulated PRU receiver with the input timings read from a file.
+//	null         - no signal is received.
+func OpenSource(spec string, gpio uint) (Source, error) {
+	name, arg := splitSpec(spec)
+	switch name {
+	case "pru":
+		return openPRUSource(gpio)
+	case "file":
+		return NewFileSource(arg)
+	case "sim":
+		return DefaultEther.Receiver(), nil
+	case "emu":
+		f, err := NewFileSource(arg)
+		if err != nil {
+			return nil, err
+		}
+		return NewEmuReceiver(gpio, f.timings), nil
+	case "null":
+		return NewMemorySource(nil), nil
+	}
+	return nil, fmt.Errorf("%s: unknown receiver type", spec)
+}
+
+// OpenSink creates a Sink from a backend specification:
+//
+//	pru          - PRU based transmitter using the gpio bit.
+//	file:<name>  - the transmitted signal timings are appended to a file.
+//	sim          - transmitter attached to the DefaultEther simulated medium
+//	               (shared only within the process).
+//	emu          - emulated PRU transmitter.
+//	null         - messages are discarded.
+func OpenSink(spec string, gpio uint) (Sink, error) {
+	name, arg := splitSpec(spec)
+	switch name {
+	case "pru":
+		return openPRUSink(gpio)
+	case "file":
+		return NewFileSink(arg)
+	case "sim":
+		return DefaultEther.Transmitter(), nil
+	case "emu":
+		return NewEmuTransmitter(gpio), nil
+	case "null":
+		return NewMemorySink(), nil
+	}
+	return nil, fmt.Errorf("%s: unknown transmitter type", spec)
+}
+
+// Capture reads up to max timings from the source.
+func Capture(s Source, max int) ([]time.Duration, error) {
+	var tm []time.Duration
+	c, err := s.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Discard remaining timings so the source is not blocked.
+		go func() {
+			for range c {
+			}
+		}()
+		s.Stop()
+	}()
+	for i := 0; i < max; i++ {
+		d, ok := <-c
+		if !ok {
+			break
+		}
+		tm = append(tm, d)
+	}
+	return tm, nil
+}
+
+// SendPrecise sends a message using the full resolution of the timings
+// if the Sink supports it, otherwise the timings are rounded to microseconds.
+func SendPrecise(s Sink, msg []time.Duration, repeats int) error {
+	if p, ok := s.(PreciseSink); ok {
+		return p.SendPrecise(msg, repeats)
+	}
+	m := make([]int, len(msg))
+	for i, d := range msg {
+		m[i] = int((d + time.Microsecond/2) / time.Microsecond)
+	}
+	return s.Send(m, repeats)
+}
+
+// Durations converts a message of microsecond timings to durations.
+func Durations(msg []int) []time.Duration {
+	d := make([]time.Duration, len(msg))
+	for i, t := range msg {
+		d[i] = time.Duration(t) * time.Microsecond
+	}
+	return d
+}
+
+// Expand converts a message to the signal that is transmitted, as a list
+// of microsecond timings starting with the output low.
+// As with the PRU transmitter, each repeat is preceded by
+// a low, high and low period of the inter-message gap.
+func Expand(msg []int, repeats int, gap int) []int {
+	signal := repeatSignal(txMessage(Durations(msg), gap), repeats)
+	out := make([]int, len(signal))
+	for i, t := range signal {
+		out[i] = int(t / time.Microsecond)
+	}
+	return out
+}
+
+func splitSpec(spec string) (string, string) {
+	i := strings.IndexByte(spec, ':')
+	if i < 0 {
+		return spec, ""
+	}
+	return spec[:i], spec[i+1:]
+}
@@ -1,12 +1,11 @@
-// Module to send raw messages to a transmitter
+//go:build linux && arm
 
-//go:generate pasm -m prutx.p prutx
-//go:generate img2go.sh prutx io
+// Module to send raw messages to a transmitter
 
 package io
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -14,78 +13,267 @@ import (
 	"github.com/aamcrae/pru"
 )
 
-const defaultGap = 5000
-const tx_event = 18
 const tx_unit = 0
 const tx_int = 2
 
 type Transmitter struct {
-	pru  *pru.PRU
-	gpio uint32
-	Gap  int
-	lock sync.Mutex
+	session *Session
+	pru     *pru.PRU
+	gpio    uint32
+	Gap     int
+	stream  bool     // Stream signals too long for the PRU RAM
+	pol     Polarity // Output polarity and idle level
+	fw      *Firmware
+	sfw     *Firmware
+	lock    sync.Mutex
+	busy    chan struct{} // Held while a message is being sent
+	stats   TxStats
 }
 
+// NewTransmitter returns a transmitter using the PRU session shared
+// with NewReceiver.
 func NewTransmitter(gpio uint) (*Transmitter, error) {
-	tx := new(Transmitter)
-	tx.Gap = defaultGap
-	tx.gpio = uint32(gpio)
-	pc := pru.NewConfig()
-	pc.EnableUnit(0).Event2Channel(tx_event, tx_int).Channel2Interrupt(tx_int, tx_int)
-	var err error
-	tx.pru, err = pru.Open(pc)
+	s, err := sharedSession()
 	if err != nil {
 		return nil, err
 	}
-	return tx, nil
+	defer s.Close()
+	return s.Transmitter(gpio)
+}
+
+// SetFirmware sets the firmware run when a message is sent.
+// A streaming transmitter firmware replaces the firmware used
+// to stream long signals.
+func (tx *Transmitter) SetFirmware(fw *Firmware) error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if fw.Type == FirmwareTxStream {
+		if err := fw.check(FirmwareTxStream, txsLayout); err != nil {
+			return err
+		}
+		tx.sfw = fw
+		return nil
+	}
+	if err := fw.check(FirmwareTx, txLayout); err != nil {
+		return err
+	}
+	tx.fw = fw
+	return nil
+}
+
+// SetStream enables streaming of signals too long to fit in the PRU RAM.
+func (tx *Transmitter) SetStream(on bool) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.stream = on
+}
+
+// SetPolarity sets the output polarity and idle level, and sets the output
+// to the idle level.
+func (tx *Transmitter) SetPolarity(p Polarity) {
+	tx.lock.Lock()
+	tx.pol = p
+	tx.lock.Unlock()
+	tx.setIdle(tx.gpio)
 }
 
+// Close the transmitter, waiting for any message being sent.
 func (tx *Transmitter) Close() {
-	tx.pru.Close()
+	tx.busy <- struct{}{}
+	defer func() { <-tx.busy }()
+	tx.session.closeTransmitter(tx)
 }
 
 // Send a message.
 func (tx *Transmitter) Send(msg []int, repeats int) error {
-	// Only one message can be sent at a time.
+	return tx.SendPrecise(Durations(msg), repeats)
+}
+
+// SendPrecise sends a message without rounding the timings to microseconds.
+func (tx *Transmitter) SendPrecise(msg []time.Duration, repeats int) error {
+	_, err := tx.transmit(context.Background(), tx.gpio, txMessage(msg, tx.Gap), repeats, Carrier{})
+	return err
+}
+
+// SendSequence sends a sequence of messages in one run of the firmware.
+func (tx *Transmitter) SendSequence(seq []Entry) error {
+	_, err := sendSequence(context.Background(), tx, tx.gpio, seq)
+	return err
+}
+
+// SendAsync sends a message without blocking the caller.
+func (tx *Transmitter) SendAsync(ctx context.Context, msg []time.Duration, repeats int) *Transmission {
+	return startTransmit(ctx, tx, tx.gpio, txMessage(msg, tx.Gap), repeats)
+}
+
+// SendSequenceAsync sends a sequence of messages without blocking the caller.
+func (tx *Transmitter) SendSequenceAsync(ctx context.Context, seq []Entry) *Transmission {
+	return startSequence(ctx, tx, tx.gpio, seq)
+}
+
+// Output returns a Sink that sends messages using a different gpio output.
+// The output is set to the idle level if it is high.
+func (tx *Transmitter) Output(gpio uint) Sink {
+	tx.lock.Lock()
+	idleHigh := tx.pol.IdleHigh
+	tx.lock.Unlock()
+	if idleHigh {
+		tx.setIdle(uint32(gpio))
+	}
+	return &output{tx: tx, gpio: uint32(gpio)}
+}
+
+// Stats returns the transmitter statistics.
+func (tx *Transmitter) Stats() TxStats {
 	tx.lock.Lock()
 	defer tx.lock.Unlock()
+	return tx.stats
+}
+
+func (tx *Transmitter) gap() int {
+	return tx.Gap
+}
+
+// transmit sends the signal on the gpio output, with the on periods
+// modulated by the carrier, returning the time taken. If the context is
+// done before the signal has been sent, the firmware is stopped and the
+// output is set to the idle level.
+func (tx *Transmitter) transmit(ctx context.Context, gpio uint32, signal []time.Duration, repeats int, c Carrier) (time.Duration, error) {
+	// Only one message can be sent at a time.
+	select {
+	case tx.busy <- struct{}{}:
+	case <-ctx.Done():
+		tx.lock.Lock()
+		tx.stats.add(0, ctx.Err())
+		tx.lock.Unlock()
+		return 0, ctx.Err()
+	}
+	defer func() { <-tx.busy }()
+	tx.lock.Lock()
+	fw, sfw, stream, pol := tx.fw, tx.sfw, tx.stream, tx.pol
+	tx.lock.Unlock()
 	u := tx.pru.Unit(tx_unit)
-	e := tx.pru.Event(tx_event)
-	r := u.Ram.Open()
-	params := []interface{}{
-		uint32(tx_event - 16),                  // Event to send when complete
-		uint32(tx.gpio),                        // GPIO to use
-		uint32(repeats),                        // Number of message repeats
-		uint32(len(msg) + 3),                   // Length of message
-		uint32(20),                             // Address of data
-		uint32(pru.MicroSeconds2Ticks(tx.Gap)), // Inter-message gap as first time
-		uint32(pru.MicroSeconds2Ticks(tx.Gap)), // Inter-message gap as first time
-		uint32(pru.MicroSeconds2Ticks(tx.Gap)), // Inter-message gap as first time
-	}
-	for _, v := range params {
-		binary.Write(r, tx.pru.Order, v)
-	}
-	tout := tx.Gap
-	for _, t := range msg {
-		binary.Write(r, tx.pru.Order, uint32(pru.MicroSeconds2Ticks(t)))
-		tout += t
-	}
-	tout *= repeats
+	ev, e := tx.events()
+	defer e.ClearHandler()
 	start := time.Now()
-	err := u.LoadAndRun(prutx_img)
+	var err error
+	if stream && len(signal) > txMax(len(u.Ram)) {
+		if c.Freq != 0 {
+			err = fmt.Errorf("carrier modulation not supported when streaming")
+		} else {
+			err = tx.sendStream(ctx, u, ev, sfw, gpio, pol, repeatSignal(signal, repeats))
+		}
+	} else {
+		err = tx.send(ctx, u, ev, fw, gpio, pol, signal, repeats, c)
+	}
+	d := time.Since(start)
+	if err != nil {
+		tx.abort(u, ev, sfw, gpio, pol)
+	}
+	tx.lock.Lock()
+	tx.stats.add(d, err)
+	tx.lock.Unlock()
+	return d, err
+}
+
+// send runs the transmitter firmware to send the signal.
+func (tx *Transmitter) send(ctx context.Context, u *pru.Unit, ev <-chan struct{}, fw *Firmware, gpio uint32, pol Polarity, signal []time.Duration, repeats int, c Carrier) error {
+	tout, err := txParams(u.Ram, tx.pru.Order, gpio, pol, signal, repeats, c, pru.MicroSeconds2Ticks)
 	if err != nil {
 		return err
 	}
+	if err := u.LoadAndRun(fw.Code); err != nil {
+		return err
+	}
 	// Timeout is twice the expected transmission time
-	timeout := time.Duration(tout*2) * time.Microsecond
-	ok, err := e.WaitTimeout(timeout)
-	if err != nil {
+	return txWait(ctx, ev, tout*2)
+}
+
+// sendStream sends a signal using the streaming firmware, refilling
+// each buffer as the firmware finishes sending it.
+func (tx *Transmitter) sendStream(ctx context.Context, u *pru.Unit, ev <-chan struct{}, sfw *Firmware, gpio uint32, pol Polarity, signal []time.Duration) error {
+	s := newTxStream(u.Ram, tx.pru.Order, gpio, pol, signal, pru.MicroSeconds2Ticks)
+	s.fill()
+	s.fill()
+	if err := u.LoadAndRun(sfw.Code); err != nil {
 		return err
 	}
-	if !ok {
-		u.Disable()
-		return fmt.Errorf("transmit timeout (%s)", time.Duration(timeout))
+	for len(s.pending) > 0 {
+		// Timeout is twice the expected time to send the buffer,
+		// allowing for scheduling delays on short buffers.
+		if err := txWait(ctx, ev, s.pending[0]*2+10*time.Millisecond); err != nil {
+			return err
+		}
+		s.sent()
+		s.fill()
+	}
+	if n := s.underruns(); n > 0 {
+		return fmt.Errorf("transmit underrun (%d buffers not ready)", n)
 	}
-	fmt.Printf("Transmission took %s\n", time.Now().Sub(start).String())
 	return nil
 }
+
+// abort stops the firmware, and sets the output to the idle level.
+func (tx *Transmitter) abort(u *pru.Unit, ev <-chan struct{}, sfw *Firmware, gpio uint32, pol Polarity) {
+	u.Disable()
+	for len(ev) > 0 {
+		<-ev
+	}
+	tx.idle(u, ev, sfw, gpio, pol)
+}
+
+// setIdle sets the gpio output to the idle level, waiting for
+// any message being sent.
+func (tx *Transmitter) setIdle(gpio uint32) {
+	tx.busy <- struct{}{}
+	defer func() { <-tx.busy }()
+	tx.lock.Lock()
+	sfw, pol := tx.sfw, tx.pol
+	tx.lock.Unlock()
+	ev, e := tx.events()
+	defer e.ClearHandler()
+	tx.idle(tx.pru.Unit(tx_unit), ev, sfw, gpio, pol)
+}
+
+// idle sets the output to the idle level by running the streaming
+// firmware with a single short period with the transmitter off.
+func (tx *Transmitter) idle(u *pru.Unit, ev <-chan struct{}, sfw *Firmware, gpio uint32, pol Polarity) {
+	s := newTxStream(u.Ram, tx.pru.Order, gpio, pol, []time.Duration{time.Microsecond}, pru.MicroSeconds2Ticks)
+	s.fill()
+	if err := u.LoadAndRun(sfw.Code); err != nil {
+		return
+	}
+	select {
+	case <-ev:
+	case <-time.After(10 * time.Millisecond):
+		u.Disable()
+	}
+}
+
+// events sets a handler for the firmware event, returning the event
+// and a channel that receives the events.
+func (tx *Transmitter) events() (<-chan struct{}, *pru.Event) {
+	ev := make(chan struct{}, 2)
+	e := tx.pru.Event(tx_event)
+	e.SetHandler(func() {
+		select {
+		case ev <- struct{}{}:
+		default:
+		}
+	})
+	return ev, e
+}
+
+// txWait waits for the firmware to signal the event.
+func txWait(ctx context.Context, ev <-chan struct{}, timeout time.Duration) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ev:
+		return nil
+	case <-t.C:
+		return fmt.Errorf("transmit timeout (%s)", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
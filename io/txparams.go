@@ -0,0 +1,110 @@
+// Parameters of the transmitter PRU firmware.
+
+package io
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TxStats holds the statistics for a transmitter.
+type TxStats struct {
+	Sent    int           // Transmissions completed
+	Failed  int           // Transmissions that failed
+	Aborted int           // Transmissions cancelled
+	Time    time.Duration // Total time spent transmitting
+	Last    time.Duration // Time taken by the last completed transmission
+}
+
+// add records the outcome of a transmission.
+func (s *TxStats) add(d time.Duration, err error) {
+	switch {
+	case err == nil:
+		s.Sent++
+		s.Last = d
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		s.Aborted++
+	default:
+		s.Failed++
+	}
+	s.Time += d
+}
+
+// Shortest timing sent, in PRU ticks. The firmware send loop takes
+// this long even when it does not wait in the delay loop.
+const txMinTicks = 12
+
+// tickConv returns a function converting a duration to PRU ticks, using
+// ticks to convert microseconds to PRU ticks.
+// The firmware delay loop takes 2 ticks, so timings are rounded
+// to an even number of ticks (10ns). The send loop takes one tick
+// more than the time given, since the other instructions take 11 ticks.
+func tickConv(ticks func(int) int) func(time.Duration) uint32 {
+	perMs := int64(ticks(1000)) / 2
+	return func(d time.Duration) uint32 {
+		t := 2 * uint32((int64(d)*perMs+int64(time.Millisecond)/2)/int64(time.Millisecond))
+		if t < txMinTicks {
+			t = txMinTicks
+		}
+		return t
+	}
+}
+
+// txMax returns the maximum number of timings that fit in the unit RAM.
+func txMax(ramSize int) int {
+	return (ramSize - txDataStart) / 4
+}
+
+// txParams writes the transmitter firmware parameters and the signal to
+// the unit RAM, using ticks to convert microseconds to PRU ticks.
+// The signal starts with the transmitter off, and is sent repeats times,
+// with the on periods modulated by the carrier. The output levels
+// are set by the polarity.
+// The expected transmission time is returned.
+func txParams(ram []byte, order binary.ByteOrder, gpio uint32, pol Polarity, signal []time.Duration, repeats int, c Carrier, ticks func(int) int) (time.Duration, error) {
+	if len(signal) > txMax(len(ram)) {
+		return 0, fmt.Errorf("message too long for PRU RAM (%d timings, maximum %d)", len(signal), txMax(len(ram)))
+	}
+	conv := tickConv(ticks)
+	var high, low uint32
+	var tm []uint32
+	if c.Freq != 0 {
+		var err error
+		if high, low, err = c.cycle(ticks); err != nil {
+			return 0, err
+		}
+		tm = carrierTimings(signal, conv, high, low)
+		// Allow for the instructions in each part of the carrier cycle.
+		high -= 4
+		low -= 2
+	} else {
+		for _, t := range signal {
+			tm = append(tm, conv(t))
+		}
+	}
+	params := []uint32{
+		uint32(tx_event - 16), // Event to send when complete
+		gpio,                  // GPIO to use
+		uint32(repeats),       // Number of message repeats
+		uint32(len(signal)),   // Length of signal
+		uint32(txDataStart),   // Address of data
+		high,                  // Carrier high time
+		low,                   // Carrier low time
+		pol.inactive(),        // Output level when off
+		pol.idle(),            // Output level after the message
+	}
+	for i, v := range params {
+		order.PutUint32(ram[i*4:], v)
+	}
+	a := len(params) * 4
+	var tout time.Duration
+	for i, t := range signal {
+		order.PutUint32(ram[a:], tm[i])
+		a += 4
+		tout += t
+	}
+	return tout * time.Duration(repeats), nil
+}
@@ -0,0 +1,109 @@
+// Streaming of signals too long for the PRU RAM.
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const txsSwitch = 16 // Extra ticks taken switching between streaming buffers
+
+// Streamer is implemented by transmitters that can stream signals
+// too long to fit in the PRU RAM.
+type Streamer interface {
+	SetStream(on bool)
+}
+
+// SetStream enables or disables streaming of long signals on a transmitter.
+func SetStream(dev interface{}, on bool) error {
+	s, ok := dev.(Streamer)
+	if !ok {
+		return fmt.Errorf("transmitter does not support streaming")
+	}
+	s.SetStream(on)
+	return nil
+}
+
+// txStream sends a signal using the double buffers of the streaming
+// transmitter firmware. A buffer is filled each time one has been sent.
+type txStream struct {
+	ram     []byte
+	order   binary.ByteOrder
+	conv    func(time.Duration) uint32
+	signal  []time.Duration
+	pos     int             // Next timing to be queued
+	bufs    [2]int          // Buffer addresses
+	size    int             // Number of timings in each buffer
+	next    int             // Next buffer to be filled
+	pending []time.Duration // Time taken to send each of the filled buffers
+}
+
+// newTxStream writes the streaming firmware parameters to the unit RAM.
+func newTxStream(ram []byte, order binary.ByteOrder, gpio uint32, pol Polarity, signal []time.Duration, ticks func(int) int) *txStream {
+	bufBytes := ((len(ram) - txsBufStart) / 2) &^ 3
+	s := &txStream{ram: ram, order: order, conv: tickConv(ticks), signal: signal, size: bufBytes/4 - 1}
+	s.bufs = [2]int{txsBufStart, txsBufStart + bufBytes}
+	params := []uint32{
+		uint32(tx_event - 16), // Event to send when a buffer has been sent
+		gpio,                  // GPIO to use
+		uint32(s.bufs[0]),     // First buffer
+		uint32(s.bufs[1]),     // Second buffer
+		0,                     // Underrun count
+		pol.inactive(),        // Output level when off
+		pol.idle(),            // Output level after the last buffer
+	}
+	for i, v := range params {
+		order.PutUint32(ram[i*4:], v)
+	}
+	for _, b := range s.bufs {
+		order.PutUint32(ram[b:], 0)
+	}
+	return s
+}
+
+// fill queues the next part of the signal in the next buffer, returning
+// false if the whole signal has already been queued.
+func (s *txStream) fill() bool {
+	if s.pos >= len(s.signal) {
+		return false
+	}
+	n := len(s.signal) - s.pos
+	if n > s.size {
+		n = s.size
+	}
+	b := s.bufs[s.next]
+	var t time.Duration
+	for i, d := range s.signal[s.pos : s.pos+n] {
+		v := s.conv(d)
+		if i == n-1 && s.pos+n < len(s.signal) && v > txsSwitch*2 {
+			// Allow for the time taken to switch to the next buffer.
+			v -= txsSwitch
+		}
+		s.order.PutUint32(s.ram[b+4+i*4:], v)
+		t += d
+	}
+	s.pos += n
+	count := uint32(n)
+	if s.pos >= len(s.signal) {
+		count |= 1 << 31
+	}
+	// Setting the count hands the buffer to the firmware.
+	s.order.PutUint32(s.ram[b:], count)
+	s.pending = append(s.pending, t)
+	s.next ^= 1
+	return true
+}
+
+// sent records that the oldest filled buffer has been sent.
+func (s *txStream) sent() {
+	if len(s.pending) > 0 {
+		s.pending = s.pending[1:]
+	}
+}
+
+// underruns returns the count of times the firmware found the next buffer not ready.
+func (s *txStream) underruns() uint32 {
+	return s.order.Uint32(s.ram[txsUnderrun:])
+}
@@ -4,17 +4,57 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
+	"time"
 )
 
+// Tagged is a message read from a RF message file, with the name of the
+// output it is sent on. The output is empty if the default output is used.
+// The carrier is the modulation of the message, such as 38kHz, or
+// empty if the message is not modulated. IR is the IR command the
+// message was encoded from, or empty if the message was captured.
+type Tagged struct {
+	Raw     Raw
+	Output  string
+	Carrier string
+	IR      string
+}
+
 // ReadTagFile reads and unpacks a RF message file
 // The format is:
-//  <tag> message-timings
+//  <tag> [output] [carrier] message-timings
 //
 // The message timings are microsecond intervals for 1-0-1-0... transitions.
+// A timing may have a fraction (e.g 412.125) for sub-microsecond resolution.
+// The optional output names the transmitter output used for the message.
+// The optional carrier is a frequency in Hz or kHz (e.g 38kHz or 38kHz/33%
+// with a duty cycle), used to modulate messages for infrared transmitters.
+// The message timings may instead be an IR command (see ParseIR), which
+// uses the usual carrier of the protocol unless a carrier is given.
 func ReadTagFile(name string) (map[string][]Raw, error) {
+	return ReadTagFileUnit(name, time.Microsecond)
+}
+
+// ReadTagFileUnit reads a RF message file, returning messages
+// with the timings in units of unit.
+func ReadTagFileUnit(name string, unit time.Duration) (map[string][]Raw, error) {
+	tagged, err := ReadTaggedFile(name, unit)
+	if tagged == nil {
+		return nil, err
+	}
 	msgs := make(map[string][]Raw)
+	for tag, tl := range tagged {
+		for _, t := range tl {
+			msgs[tag] = append(msgs[tag], t.Raw)
+		}
+	}
+	return msgs, err
+}
+
+// ReadTaggedFile reads a RF message file, returning the messages with the
+// timings in units of unit, along with the output for each message.
+func ReadTaggedFile(name string, unit time.Duration) (map[string][]Tagged, error) {
+	msgs := make(map[string][]Tagged)
 	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
@@ -25,22 +65,52 @@ func ReadTagFile(name string) (map[string][]Raw, error) {
 	for scan.Scan() {
 		lineno++
 		strs := strings.Split(scan.Text(), " ")
-		if len(strs) != 2 {
+		if len(strs) < 2 || len(strs) > 4 {
 			return msgs, fmt.Errorf("%s: line %d: unknown format", name, lineno)
 		}
+		var output, carrier string
+		for _, f := range strs[1 : len(strs)-1] {
+			if strings.Contains(strings.ToLower(f), "hz") {
+				if len(carrier) != 0 {
+					return msgs, fmt.Errorf("%s: line %d: more than one carrier", name, lineno)
+				}
+				carrier = f
+			} else {
+				if len(output) != 0 {
+					return msgs, fmt.Errorf("%s: line %d: more than one output", name, lineno)
+				}
+				output = f
+			}
+		}
+		strs = []string{strs[0], strs[len(strs)-1]}
+		if IsIR(strs[1]) {
+			c, err := ParseIR(strs[1])
+			if err != nil {
+				return msgs, fmt.Errorf("%s: line %d: %v", name, lineno, err)
+			}
+			raw, err := c.Encode(unit)
+			if err != nil {
+				return msgs, fmt.Errorf("%s: line %d: %v", name, lineno, err)
+			}
+			if len(carrier) == 0 {
+				carrier = c.Carrier()
+			}
+			msgs[strs[0]] = append(msgs[strs[0]], Tagged{Raw: raw, Output: output, Carrier: carrier, IR: c.String()})
+			continue
+		}
 		ts := strings.Split(strs[1], ",")
 		if len(ts) < 5 {
 			return msgs, fmt.Errorf("%s: line %d: Bad message length", name, lineno)
 		}
 		var raw []int
 		for i, t := range ts {
-			v, err := strconv.ParseInt(t, 10, 32)
+			v, err := parseTiming(t)
 			if err != nil {
 				return msgs, fmt.Errorf("%s: line %d, timing %d (%s) bad format", name, lineno, i, t)
 			}
-			raw = append(raw, int(v))
+			raw = append(raw, int((v+unit/2)/unit))
 		}
-		msgs[strs[0]] = append(msgs[strs[0]], Raw(raw))
+		msgs[strs[0]] = append(msgs[strs[0]], Tagged{Raw: raw, Output: output, Carrier: carrier})
 	}
 	return msgs, nil
 }
@@ -0,0 +1,492 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Infrared remote control protocols.
+const (
+	NEC    = "nec"
+	RC5    = "rc5"
+	RC6    = "rc6"
+	Sony12 = "sony12"
+	Sony15 = "sony15"
+	Sony20 = "sony20"
+)
+
+const irTolerance = 30 // Percent tolerance when decoding IR timings
+
+const (
+	necUnit  = 562500 * time.Nanosecond
+	rc5Unit  = 889 * time.Microsecond
+	rc6Unit  = 444 * time.Microsecond
+	sonyUnit = 600 * time.Microsecond
+)
+
+var errNotIR = errors.New("not a known IR protocol")
+
+// IR is an infrared remote control command. The messages start
+// with the IR LED on (a mark), and the timings of each
+// protocol are sent using its usual carrier frequency.
+type IR struct {
+	Protocol string
+	Address  int
+	Command  int
+	Toggle   bool // RC5 and RC6 toggle bit, flipped on each new key press
+	Repeat   bool // NEC repeat code, sent while a key is held
+}
+
+// ParseIR parses an IR command in the form written by String:
+//
+//	nec:<address>:<command>
+//	nec:repeat
+//	rc5:<address>:<command>[:toggle]
+//	rc6:<address>:<command>[:toggle]
+//	sony12:<address>:<command> (also sony15 and sony20)
+//
+// The address and command may be decimal or hex (0x...), and
+// the toggle is 0 or 1.
+func ParseIR(s string) (IR, error) {
+	var c IR
+	f := strings.Split(s, ":")
+	c.Protocol = f[0]
+	switch c.Protocol {
+	case NEC:
+		if len(f) == 2 && f[1] == "repeat" {
+			c.Repeat = true
+			return c, nil
+		}
+		if len(f) != 3 {
+			return c, fmt.Errorf("%s: bad NEC command", s)
+		}
+	case RC5, RC6:
+		if len(f) == 4 {
+			switch f[3] {
+			case "0":
+			case "1":
+				c.Toggle = true
+			default:
+				return c, fmt.Errorf("%s: bad toggle", s)
+			}
+			f = f[:3]
+		}
+		if len(f) != 3 {
+			return c, fmt.Errorf("%s: bad %s command", s, c.Protocol)
+		}
+	case Sony12, Sony15, Sony20:
+		if len(f) != 3 {
+			return c, fmt.Errorf("%s: bad Sony command", s)
+		}
+	default:
+		return c, fmt.Errorf("%s: unknown IR protocol", s)
+	}
+	a, err := strconv.ParseUint(f[1], 0, 16)
+	if err != nil {
+		return c, fmt.Errorf("%s: bad address", s)
+	}
+	cmd, err := strconv.ParseUint(f[2], 0, 8)
+	if err != nil {
+		return c, fmt.Errorf("%s: bad command", s)
+	}
+	c.Address = int(a)
+	c.Command = int(cmd)
+	return c, c.check()
+}
+
+// IsIR returns true if the string is an IR command rather than message timings.
+func IsIR(s string) bool {
+	p, _, _ := strings.Cut(s, ":")
+	switch p {
+	case NEC, RC5, RC6, Sony12, Sony15, Sony20:
+		return true
+	}
+	return false
+}
+
+func (c IR) String() string {
+	switch {
+	case c.Protocol == NEC && c.Repeat:
+		return "nec:repeat"
+	case c.Protocol == RC5 || c.Protocol == RC6:
+		t := 0
+		if c.Toggle {
+			t = 1
+		}
+		return fmt.Sprintf("%s:%#x:%#x:%d", c.Protocol, c.Address, c.Command, t)
+	}
+	return fmt.Sprintf("%s:%#x:%#x", c.Protocol, c.Address, c.Command)
+}
+
+// Carrier returns the usual carrier frequency of the protocol.
+func (c IR) Carrier() string {
+	switch c.Protocol {
+	case NEC:
+		return "38kHz"
+	case RC5, RC6:
+		return "36kHz"
+	}
+	return "40kHz"
+}
+
+// check verifies that the address and command fit the protocol.
+func (c IR) check() error {
+	maxAddr, maxCmd := 0, 0
+	switch c.Protocol {
+	case NEC:
+		maxAddr, maxCmd = 0xFFFF, 0xFF
+	case RC5:
+		maxAddr, maxCmd = 0x1F, 0x7F
+	case RC6:
+		maxAddr, maxCmd = 0xFF, 0xFF
+	case Sony12:
+		maxAddr, maxCmd = 0x1F, 0x7F
+	case Sony15:
+		maxAddr, maxCmd = 0xFF, 0x7F
+	case Sony20:
+		maxAddr, maxCmd = 0x1FFF, 0x7F
+	default:
+		return fmt.Errorf("%s: unknown IR protocol", c.Protocol)
+	}
+	if c.Address < 0 || c.Address > maxAddr {
+		return fmt.Errorf("%s: address %#x out of range", c, c.Address)
+	}
+	if c.Command < 0 || c.Command > maxCmd {
+		return fmt.Errorf("%s: command %#x out of range", c, c.Command)
+	}
+	return nil
+}
+
+// Encode returns the message for the command, with timings in units of unit.
+func (c IR) Encode(unit time.Duration) (Raw, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	var d []time.Duration
+	switch c.Protocol {
+	case NEC:
+		d = encodeNEC(c)
+	case RC5:
+		d = encodeRC5(c)
+	case RC6:
+		d = encodeRC6(c)
+	default:
+		d = encodeSony(c)
+	}
+	return NewRaw(d, unit), nil
+}
+
+// irDecoders are the decoders for each protocol, along with
+// the possible lengths of the first mark of a message.
+var irDecoders = []struct {
+	decode func([]time.Duration) (IR, bool)
+	lead   []time.Duration
+}{
+	{decodeNEC, []time.Duration{16 * necUnit}},
+	{decodeRC5, []time.Duration{rc5Unit, 2 * rc5Unit}},
+	{decodeRC6, []time.Duration{6 * rc6Unit}},
+	{decodeSony, []time.Duration{4 * sonyUnit}},
+}
+
+// DecodeIR decodes a message with timings in units of unit as an IR command.
+// A Listener does not return the first mark of a message, so if the message
+// cannot be decoded, the first mark of each protocol is restored and
+// the message is decoded again.
+func DecodeIR(m Raw, unit time.Duration) (IR, error) {
+	d := m.Durations(unit)
+	for _, dec := range irDecoders {
+		if c, ok := dec.decode(d); ok {
+			return c, nil
+		}
+	}
+	for _, dec := range irDecoders {
+		for _, l := range dec.lead {
+			if c, ok := dec.decode(append([]time.Duration{l}, d...)); ok {
+				return c, nil
+			}
+		}
+	}
+	return IR{}, errNotIR
+}
+
+// near returns true if the timing is within the tolerance of n units.
+func near(d time.Duration, n int, unit time.Duration) bool {
+	want := time.Duration(n) * unit
+	allow := want * irTolerance / 100
+	return d > want-allow && d < want+allow
+}
+
+// units returns the number of whole units in the timing, or 0 if
+// the timing is not close to a whole number of units.
+func units(d, unit time.Duration) int {
+	n := int((d + unit/2) / unit)
+	if n == 0 || d-time.Duration(n)*unit > unit*irTolerance/100 || time.Duration(n)*unit-d > unit*irTolerance/100 {
+		return 0
+	}
+	return n
+}
+
+// NEC: a 16 unit mark and 8 unit space, then 32 bits (least significant
+// first) of address, inverted address, command and inverted command.
+// Each bit is a 1 unit mark followed by a 1 unit (0) or 3 unit (1) space,
+// and the message ends with a 1 unit mark. An address greater than 0xFF
+// is an extended address replacing the inverted address.
+// The repeat code is a 16 unit mark, 4 unit space and 1 unit mark.
+func encodeNEC(c IR) []time.Duration {
+	if c.Repeat {
+		return []time.Duration{16 * necUnit, 4 * necUnit, necUnit}
+	}
+	v := uint32(c.Address)
+	if c.Address <= 0xFF {
+		v |= uint32(^c.Address&0xFF) << 8
+	}
+	v |= uint32(c.Command)<<16 | uint32(^c.Command&0xFF)<<24
+	d := []time.Duration{16 * necUnit, 8 * necUnit}
+	for i := 0; i < 32; i++ {
+		space := necUnit
+		if v&(1<<i) != 0 {
+			space = 3 * necUnit
+		}
+		d = append(d, necUnit, space)
+	}
+	return append(d, necUnit)
+}
+
+func decodeNEC(d []time.Duration) (IR, bool) {
+	c := IR{Protocol: NEC}
+	if len(d) == 3 && near(d[0], 16, necUnit) && near(d[1], 4, necUnit) && near(d[2], 1, necUnit) {
+		c.Repeat = true
+		return c, true
+	}
+	if len(d) != 67 || !near(d[0], 16, necUnit) || !near(d[1], 8, necUnit) {
+		return c, false
+	}
+	var v uint32
+	for i := 0; i < 32; i++ {
+		if !near(d[2+i*2], 1, necUnit) {
+			return c, false
+		}
+		switch s := d[3+i*2]; {
+		case near(s, 3, necUnit):
+			v |= 1 << i
+		case !near(s, 1, necUnit):
+			return c, false
+		}
+	}
+	if !near(d[66], 1, necUnit) {
+		return c, false
+	}
+	c.Command = int(v>>16) & 0xFF
+	if c.Command != int(^v>>24)&0xFF {
+		return c, false
+	}
+	c.Address = int(v & 0xFFFF)
+	if c.Address&0xFF == int(^v>>8)&0xFF {
+		c.Address &= 0xFF
+	}
+	return c, true
+}
+
+// halves converts a list of levels, each lasting one unit, to timings.
+// The leading and trailing spaces are dropped, since they are part of
+// the gap between messages.
+func halves(levels []bool, unit time.Duration) []time.Duration {
+	var d []time.Duration
+	for len(levels) > 0 && !levels[0] {
+		levels = levels[1:]
+	}
+	for len(levels) > 0 && !levels[len(levels)-1] {
+		levels = levels[:len(levels)-1]
+	}
+	for i, l := range levels {
+		if i > 0 && l == levels[i-1] {
+			d[len(d)-1] += unit
+		} else {
+			d = append(d, unit)
+		}
+	}
+	return d
+}
+
+// levels converts timings starting with a mark to a list of
+// levels each lasting one unit, or returns nil if the timings
+// are not whole numbers of units up to max.
+func levels(d []time.Duration, unit time.Duration, max int) []bool {
+	var l []bool
+	for i, t := range d {
+		n := units(t, unit)
+		if n == 0 || n > max {
+			return nil
+		}
+		for j := 0; j < n; j++ {
+			l = append(l, i%2 == 0)
+		}
+	}
+	return l
+}
+
+// manchester decodes pairs of levels as bits, where a 1 is
+// encoded as the pair one.
+func manchester(l []bool, one [2]bool) (uint32, bool) {
+	var v uint32
+	for i := 0; i+1 < len(l); i += 2 {
+		if l[i] == l[i+1] {
+			return 0, false
+		}
+		v <<= 1
+		if l[i] == one[0] {
+			v |= 1
+		}
+	}
+	return v, true
+}
+
+// RC5: 14 bi-phase bits (most significant first) of 2 half bit units,
+// where a 1 is a space then a mark. The bits are a start bit (1),
+// a field bit (the inverse of command bit 6), the toggle bit,
+// a 5 bit address and the low 6 bits of the command.
+func encodeRC5(c IR) []time.Duration {
+	v := 1<<13 | (^c.Command>>6&1)<<12 | c.Address<<6 | c.Command&0x3F
+	if c.Toggle {
+		v |= 1 << 11
+	}
+	var l []bool
+	for i := 13; i >= 0; i-- {
+		b := v&(1<<i) != 0
+		l = append(l, !b, b)
+	}
+	return halves(l, rc5Unit)
+}
+
+func decodeRC5(d []time.Duration) (IR, bool) {
+	c := IR{Protocol: RC5}
+	// The message starts with the mark of the start bit.
+	l := levels(d, rc5Unit, 2)
+	if l == nil {
+		return c, false
+	}
+	l = append([]bool{false}, l...)
+	if len(l) == 27 {
+		// The last bit is a 0, with the space merged with the gap.
+		l = append(l, false)
+	}
+	if len(l) != 28 {
+		return c, false
+	}
+	v, ok := manchester(l, [2]bool{false, true})
+	if !ok || v&(1<<13) == 0 {
+		return c, false
+	}
+	c.Toggle = v&(1<<11) != 0
+	c.Address = int(v>>6) & 0x1F
+	c.Command = int(v&0x3F) | int(^v>>12&1)<<6
+	return c, true
+}
+
+// RC6 (mode 0): a 6 unit mark and 2 unit space, then bi-phase bits where
+// a 1 is a mark then a space. The bits are a start bit (1), 3 mode bits,
+// the toggle bit (of double length), an 8 bit address and 8 bit command.
+func encodeRC6(c IR) []time.Duration {
+	l := []bool{true, true, true, true, true, true, false, false}
+	bit := func(b bool, n int) {
+		for i := 0; i < n; i++ {
+			l = append(l, b)
+		}
+		for i := 0; i < n; i++ {
+			l = append(l, !b)
+		}
+	}
+	bit(true, 1)
+	for i := 0; i < 3; i++ {
+		bit(false, 1)
+	}
+	bit(c.Toggle, 2)
+	v := c.Address<<8 | c.Command
+	for i := 15; i >= 0; i-- {
+		bit(v&(1<<i) != 0, 1)
+	}
+	return halves(l, rc6Unit)
+}
+
+func decodeRC6(d []time.Duration) (IR, bool) {
+	c := IR{Protocol: RC6}
+	if len(d) < 3 || !near(d[0], 6, rc6Unit) || !near(d[1], 2, rc6Unit) {
+		return c, false
+	}
+	l := levels(d[2:], rc6Unit, 3)
+	if len(l) == 43 {
+		// The last bit is a 1, with the space merged with the gap.
+		l = append(l, false)
+	}
+	if len(l) != 44 {
+		return c, false
+	}
+	// Start and mode bits.
+	if v, ok := manchester(l[:8], [2]bool{true, false}); !ok || v != 8 {
+		return c, false
+	}
+	// The double length toggle bit.
+	if l[8] != l[9] || l[10] != l[11] || l[8] == l[10] {
+		return c, false
+	}
+	c.Toggle = l[8]
+	v, ok := manchester(l[12:], [2]bool{true, false})
+	if !ok {
+		return c, false
+	}
+	c.Address = int(v >> 8)
+	c.Command = int(v & 0xFF)
+	return c, true
+}
+
+// Sony SIRC: a 4 unit mark, then 12, 15 or 20 bits (least significant
+// first) of a 7 bit command and a 5, 8 or 13 bit address. Each bit is
+// a 1 unit space followed by a 1 unit (0) or 2 unit (1) mark.
+func encodeSony(c IR) []time.Duration {
+	n := map[string]int{Sony12: 12, Sony15: 15, Sony20: 20}[c.Protocol]
+	v := c.Address<<7 | c.Command
+	d := []time.Duration{4 * sonyUnit}
+	for i := 0; i < n; i++ {
+		mark := sonyUnit
+		if v&(1<<i) != 0 {
+			mark = 2 * sonyUnit
+		}
+		d = append(d, sonyUnit, mark)
+	}
+	return d
+}
+
+func decodeSony(d []time.Duration) (IR, bool) {
+	var c IR
+	switch len(d) {
+	case 25:
+		c.Protocol = Sony12
+	case 31:
+		c.Protocol = Sony15
+	case 41:
+		c.Protocol = Sony20
+	default:
+		return c, false
+	}
+	if !near(d[0], 4, sonyUnit) {
+		return c, false
+	}
+	v := 0
+	for i := 0; i < len(d)/2; i++ {
+		if !near(d[1+i*2], 1, sonyUnit) {
+			return c, false
+		}
+		switch m := d[2+i*2]; {
+		case near(m, 2, sonyUnit):
+			v |= 1 << i
+		case !near(m, 1, sonyUnit):
+			return c, false
+		}
+	}
+	c.Command = v & 0x7F
+	c.Address = v >> 7
+	return c, true
+}
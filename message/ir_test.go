@@ -0,0 +1,91 @@
+package message
+
+import (
+	"testing"
+	"time"
+)
+
+var irCommands = []string{
+	"nec:0x04:0x08",
+	"nec:0x1234:0xFF",
+	"nec:repeat",
+	"rc5:0x05:0x35",
+	"rc5:0x1F:0x00:1",
+	"rc6:0x00:0x0C",
+	"rc6:0xFF:0xFF:1",
+	"sony12:0x01:0x15",
+	"sony15:0xA4:0x3A",
+	"sony20:0x1FFF:0x7F",
+}
+
+// listenIR passes the message through a Listener, preceded and
+// followed by a gap, and returns the message the Listener extracts.
+func listenIR(t *testing.T, m Raw, unit time.Duration) Raw {
+	t.Helper()
+	l := NewPreciseListener(unit)
+	l.Gap = int(10 * time.Millisecond / unit)
+	l.MinLen = 2
+	gap := int(50 * time.Millisecond / unit)
+	l.Next(gap)
+	for _, v := range m {
+		if r := l.Next(v); r != nil {
+			t.Fatalf("message returned before the gap: %v", r)
+		}
+	}
+	if len(m)%2 == 0 {
+		// The messages end with a mark, so that the gap can follow.
+		t.Fatalf("message ends with a space: %v", m)
+	}
+	r := l.Next(gap)
+	if r == nil {
+		t.Fatalf("no message returned (noise %d, runts %d)", l.Noise, l.Runt)
+	}
+	return r
+}
+
+func TestIRRoundTrip(t *testing.T) {
+	for _, unit := range []time.Duration{time.Microsecond, time.Nanosecond} {
+		for _, s := range irCommands {
+			c, err := ParseIR(s)
+			if err != nil {
+				t.Fatalf("%s: %v", s, err)
+			}
+			m, err := c.Encode(unit)
+			if err != nil {
+				t.Fatalf("%s: %v", s, err)
+			}
+			d, err := DecodeIR(listenIR(t, m, unit), unit)
+			if err != nil {
+				t.Errorf("%s (unit %s): %v", s, unit, err)
+				continue
+			}
+			if d != c {
+				t.Errorf("%s (unit %s): decoded as %s", s, unit, d)
+			}
+		}
+	}
+}
+
+func TestIRString(t *testing.T) {
+	for _, s := range irCommands {
+		c, err := ParseIR(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		c2, err := ParseIR(c.String())
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if c2 != c {
+			t.Errorf("%s: parsed %s as %s", s, c, c2)
+		}
+	}
+}
+
+func TestIRErrors(t *testing.T) {
+	for _, s := range []string{"nec:0x10000:0", "rc5:0x20:0", "sony12:0:0x80", "foo:1:2", "nec:1"} {
+		if _, err := ParseIR(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
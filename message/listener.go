@@ -1,25 +1,61 @@
 package message
 
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Levels of the gap between messages, relative to a stream
+// of timings that starts with the signal low.
+const (
+	GapAuto = -1 // The gap level is detected from the timings
+	GapLow  = 0  // The gap is low, as from most receivers
+	GapHigh = 1  // The gap is high, as from receivers that idle high or invert the signal
+)
+
+// Listener extracts messages from a stream of timings. The timings,
+// and the limits such as Gap and MinPulse, are in units of Unit.
+// GapLevel is the level of the gap between messages. If it is
+// GapAuto, the level of most of the timings longer than Gap is used,
+// which also corrects for a stream that does not start low.
+// The first period after the gap is not included in a message, so
+// the first timing of a message is at the level of the gap.
 type Listener struct {
 	timings       Raw
 	bit           int
+	flushed       bool   // Pending message completed by Idle
+	gaps          [2]int // Timings longer than the gap seen at each level
+	level         int    // Detected gap level
 	Gap           int
+	GapLevel      int
 	MinLen        int
 	MaxLen        int
 	MinPulse      int
 	ShortestPulse int
+	Unit          time.Duration
 
 	Noise    int
 	Overflow int
 	Runt     int
+	Lost     int
 }
 
+// NewListener returns a Listener for microsecond timings.
 func NewListener() *Listener {
+	return NewPreciseListener(time.Microsecond)
+}
+
+// NewPreciseListener returns a Listener for timings in units of unit,
+// such as time.Nanosecond to preserve the resolution of the receiver.
+func NewPreciseListener(unit time.Duration) *Listener {
 	l := new(Listener)
-	l.Gap = 4000
+	l.Unit = unit
+	l.Gap = int(4000 * time.Microsecond / unit)
 	l.MinLen = 10
 	l.MaxLen = 200
-	l.MinPulse = 20
+	l.MinPulse = int(20 * time.Microsecond / unit)
+	l.GapLevel = GapLow
 	l.Clear()
 	return l
 }
@@ -27,13 +63,54 @@ func NewListener() *Listener {
 func (l *Listener) Clear() {
 	l.bit = 0
 	l.timings = nil
+	l.flushed = false
+	l.gaps = [2]int{}
+	l.level = GapAuto
 	l.Noise = 0
 	l.Overflow = 0
 	l.Runt = 0
+	l.Lost = 0
 	l.ShortestPulse = l.Gap + 1
 }
 
+// NextDuration converts the timing to the Listener's units, and
+// returns a message if one has been completed.
+func (l *Listener) NextDuration(d time.Duration) Raw {
+	if d < 0 {
+		return l.Next(-1)
+	}
+	return l.Next(l.units(d + l.Unit/2))
+}
+
+// units converts a duration to the Listener's units. Long durations are
+// limited to the largest int on all platforms, since they only need to be
+// recognised as longer than the gap.
+func (l *Listener) units(d time.Duration) int {
+	v := d / l.Unit
+	if v > math.MaxInt32 {
+		v = math.MaxInt32
+	}
+	return int(v)
+}
+
 func (l *Listener) Next(tv int) Raw {
+	flushed := l.flushed
+	l.flushed = false
+	if tv < 0 {
+		// Timings have been lost, discard message and wait for the next gap.
+		if l.timings != nil {
+			l.Lost++
+		}
+		l.timings = nil
+		// Sources restart from a low period after timings are lost.
+		l.bit = 0
+		if l.GapLevel == GapAuto {
+			// The number of timings lost is unknown, so detect the level again.
+			l.gaps = [2]int{}
+			l.level = GapAuto
+		}
+		return nil
+	}
 	b := l.bit
 	l.bit ^= 1 // flip bit
 	if tv < l.MinPulse {
@@ -45,11 +122,16 @@ func (l *Listener) Next(tv int) Raw {
 		return nil
 	}
 	// Check for end of message gap.
-	if b == 0 && tv > l.Gap {
+	if tv > l.Gap && l.isGap(b) {
+		if flushed {
+			// The message was completed by Idle.
+			l.timings = make([]int, 0)
+			return nil
+		}
 		if len(l.timings) >= l.MinLen && len(l.timings) < l.MaxLen {
 			t := l.timings
 			l.timings = make([]int, 0)
-			return t[1:] // Return message, skipping inter-message gap.
+			return t[1:] // Return message, skipping the first period after the gap.
 		} else {
 			// Discard out-of-range message.
 			l.Runt++
@@ -71,6 +153,76 @@ func (l *Listener) Next(tv int) Raw {
 	return nil
 }
 
+// Level returns the level of the gap between messages, which is
+// GapAuto if it has not yet been detected.
+func (l *Listener) Level() int {
+	if l.GapLevel != GapAuto {
+		return l.GapLevel
+	}
+	return l.level
+}
+
+// isGap records a timing longer than the gap at level b, and returns
+// true if it is the gap between messages.
+func (l *Listener) isGap(b int) bool {
+	if l.GapLevel != GapAuto {
+		return b == l.GapLevel
+	}
+	l.gaps[b]++
+	if l.gaps[b] > l.gaps[b^1] {
+		l.level = b
+	}
+	return b == l.level
+}
+
+// ParseGapLevel parses the level of the gap between messages,
+// which is one of low, high or auto.
+func ParseGapLevel(s string) (int, error) {
+	switch s {
+	case "low":
+		return GapLow, nil
+	case "high":
+		return GapHigh, nil
+	case "auto":
+		return GapAuto, nil
+	}
+	return GapAuto, fmt.Errorf("%s: unknown gap level (low, high or auto)", s)
+}
+
+// Timeout returns how long the signal must be idle before
+// a pending message can be completed by Idle.
+func (l *Listener) Timeout() time.Duration {
+	return time.Duration(l.Gap+1) * l.Unit
+}
+
+// IdleDuration converts the idle time to the Listener's units, and
+// returns a message if one has been completed.
+func (l *Listener) IdleDuration(d time.Duration) Raw {
+	return l.Idle(l.units(d))
+}
+
+// Idle is called when there has been no edge for tv since the last timing,
+// since a receiver only delivers the gap after a message when the next edge
+// arrives. If the signal is at the gap level and has been for longer than the
+// gap, the pending message is completed and returned, and the gap is ignored
+// when it arrives.
+// Sources that deliver timings in blocks may still hold the rest of a
+// message, so if the next timing is not the gap, timings are discarded
+// until a gap is seen.
+func (l *Listener) Idle(tv int) Raw {
+	if l.bit != l.Level() || tv <= l.Gap || l.timings == nil || l.flushed {
+		return nil
+	}
+	t := l.timings
+	l.timings = nil
+	l.flushed = true
+	if len(t) >= l.MinLen && len(t) < l.MaxLen {
+		return t[1:] // Return message, skipping the first period after the gap.
+	}
+	l.Runt++
+	return nil
+}
+
 // Given a slice of raw timings, extract all the messages.
 func (l *Listener) Decode(rawInput []int) []Raw {
 	l.Clear()
@@ -0,0 +1,195 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testGap = 10000 // Gap between test messages, in microseconds
+
+var testMsgs = []Raw{
+	{500, 1000, 500, 1000, 500, 1000, 500, 1000, 500, 1000, 500},
+	{900, 400, 900, 400, 900, 400, 900, 400, 900, 400, 900, 400, 900},
+}
+
+// gapStream returns a stream of timings that starts low, with each
+// message preceded by a gap at level, and followed by a final gap.
+func gapStream(level int, msgs ...Raw) []int {
+	var s []int
+	if level == GapHigh {
+		s = append(s, 300)
+	}
+	for _, m := range msgs {
+		s = append(s, testGap)
+		s = append(s, m...)
+	}
+	return append(s, testGap)
+}
+
+// checkMsgs checks that the messages extracted are the test messages,
+// less the first period after each gap.
+func checkMsgs(t *testing.T, name string, got []Raw, want ...Raw) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d messages, expected %d (%v)", name, len(got), len(want), got)
+	}
+	for i, m := range got {
+		if !reflect.DeepEqual(m, want[i][1:]) {
+			t.Errorf("%s: message %d is %v, expected %v", name, i, m, want[i][1:])
+		}
+	}
+}
+
+func TestListenerGapLevel(t *testing.T) {
+	for _, gl := range []int{GapLow, GapHigh} {
+		l := NewListener()
+		l.GapLevel = gl
+		checkMsgs(t, "matching level", l.Decode(gapStream(gl, testMsgs...)), testMsgs...)
+		if l.Noise != 0 || l.Overflow != 0 {
+			t.Errorf("gap level %d: %d noise, %d overflows", gl, l.Noise, l.Overflow)
+		}
+		// A stream with the gap at the other level has no messages.
+		if m := l.Decode(gapStream(gl^1, testMsgs...)); len(m) != 0 {
+			t.Errorf("gap level %d: %d messages from a stream with the gap at level %d", gl, len(m), gl^1)
+		}
+	}
+}
+
+func TestListenerGapAuto(t *testing.T) {
+	for _, gl := range []int{GapLow, GapHigh} {
+		l := NewListener()
+		l.GapLevel = GapAuto
+		if lv := l.Level(); lv != GapAuto {
+			t.Errorf("level %d before any gap, expected %d", lv, GapAuto)
+		}
+		checkMsgs(t, "auto", l.Decode(gapStream(gl, testMsgs...)), testMsgs...)
+		if lv := l.Level(); lv != gl {
+			t.Errorf("detected level %d, expected %d", lv, gl)
+		}
+	}
+}
+
+// TestListenerGapAutoDropped checks that the gap level is detected
+// again after timings are lost, since the phase of the stream is then unknown.
+func TestListenerGapAutoDropped(t *testing.T) {
+	l := NewListener()
+	l.GapLevel = GapAuto
+	var got []Raw
+	next := func(s []int) {
+		for _, v := range s {
+			if m := l.Next(v); m != nil {
+				got = append(got, m)
+			}
+		}
+	}
+	next(gapStream(GapLow, testMsgs[0]))
+	// Lose timings part way through a message. The timings after
+	// the loss start low, and the gap is now seen at the other level.
+	next([]int{500, -1, 500})
+	next(gapStream(GapLow, testMsgs[1]))
+	if lv := l.Level(); lv != GapHigh {
+		t.Errorf("level %d after timings lost, expected %d", lv, GapHigh)
+	}
+	if l.Lost != 1 {
+		t.Errorf("%d messages lost, expected 1", l.Lost)
+	}
+	checkMsgs(t, "dropped", got, testMsgs...)
+}
+
+// TestListenerDropped checks that with a fixed gap level, the level of the
+// timings is restarted after timings are lost, since sources restart
+// from a low period.
+func TestListenerDropped(t *testing.T) {
+	for _, gl := range []int{GapLow, GapHigh} {
+		l := NewListener()
+		l.GapLevel = gl
+		// Lose timings part way through a message while the signal is high.
+		s := gapStream(gl, testMsgs[0])[:7]
+		s = append(append(s, -1), gapStream(gl, testMsgs[1])...)
+		checkMsgs(t, "dropped", l.Decode(s), testMsgs[1])
+		if l.Lost != 1 {
+			t.Errorf("gap level %d: %d messages lost, expected 1", gl, l.Lost)
+		}
+	}
+}
+
+func TestListenerIdle(t *testing.T) {
+	for _, gl := range []int{GapLow, GapHigh} {
+		l := NewListener()
+		l.GapLevel = gl
+		s := gapStream(gl, testMsgs[0])
+		s = s[:len(s)-1] // Hold back the final gap.
+		for i, v := range s {
+			if m := l.Next(v); m != nil {
+				t.Fatalf("message returned before the gap: %v", m)
+			}
+			if i == len(s)-2 && l.Idle(testGap) != nil {
+				t.Errorf("gap level %d: message completed when idle at the wrong level", gl)
+			}
+		}
+		if m := l.Idle(l.Gap); m != nil {
+			t.Errorf("gap level %d: message completed before the gap", gl)
+		}
+		checkMsgs(t, "idle", []Raw{l.Idle(l.Gap + 1)}, testMsgs[0])
+		if m := l.Idle(testGap); m != nil {
+			t.Errorf("gap level %d: message completed twice by Idle", gl)
+		}
+		// The gap arriving after Idle does not complete the message again.
+		if m := l.Next(testGap); m != nil {
+			t.Errorf("gap level %d: message completed again by the gap: %v", gl, m)
+		}
+		var got []Raw
+		for _, v := range append(testMsgs[1], testGap) {
+			if m := l.Next(v); m != nil {
+				got = append(got, m)
+			}
+		}
+		checkMsgs(t, "after idle", got, testMsgs[1])
+	}
+}
+
+// TestListenerIdleLate checks that when a source delivers the rest of
+// a message after it has been completed by Idle, the rest is discarded.
+func TestListenerIdleLate(t *testing.T) {
+	l := NewListener()
+	l.MinLen = 2
+	m := testMsgs[0]
+	for _, v := range append([]int{testGap}, m[:7]...) {
+		l.Next(v)
+	}
+	if r := l.Idle(testGap); r == nil {
+		t.Fatalf("message not completed by Idle")
+	}
+	var got []Raw
+	for _, v := range append(append(m[7:], testGap), append(testMsgs[1], testGap)...) {
+		if r := l.Next(v); r != nil {
+			got = append(got, r)
+		}
+	}
+	checkMsgs(t, "late", got, testMsgs[1])
+}
+
+func TestListenerIdleDuration(t *testing.T) {
+	l := NewPreciseListener(time.Nanosecond)
+	l.MinLen = 2
+	for _, v := range gapStream(GapLow, testMsgs[0])[:len(testMsgs[0])+1] {
+		l.NextDuration(time.Duration(v) * time.Microsecond)
+	}
+	if m := l.IdleDuration(l.Timeout() - l.Unit); m != nil {
+		t.Errorf("message completed before the timeout")
+	}
+	m := l.IdleDuration(l.Timeout())
+	if len(m) != len(testMsgs[0])-1 || m[0] != 1000000 {
+		t.Errorf("message %v, expected %d nanosecond timings", m, len(testMsgs[0])-1)
+	}
+	// Long idle times are limited rather than overflowing.
+	l.Clear()
+	l.Next(l.Gap + 1)
+	l.Next(100000)
+	l.Next(100000)
+	l.Next(100000)
+	if m := l.IdleDuration(time.Hour * 24 * 365); m == nil {
+		t.Errorf("message not completed after a long idle time")
+	}
+}
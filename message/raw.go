@@ -5,10 +5,33 @@ import (
 	"math"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Raw is a message as a list of timings. The timings are
+// usually microseconds, but may be in a finer unit.
 type Raw []int
 
+// NewRaw converts durations to a message with timings in units of unit.
+func NewRaw(d []time.Duration, unit time.Duration) Raw {
+	m := make(Raw, len(d))
+	for i, t := range d {
+		m[i] = int((t + unit/2) / unit)
+	}
+	return m
+}
+
+// Durations converts a message with timings in units of unit to durations.
+func (m Raw) Durations(unit time.Duration) []time.Duration {
+	d := make([]time.Duration, len(m))
+	for i, t := range m {
+		d[i] = time.Duration(t) * unit
+	}
+	return d
+}
+
 // Analyse one raw message, trying to estimate the base sync time by
 // finding the closest GCD of the message segments.
 func (m Raw) Analyse(tolerance int) (int, int, int) {
@@ -82,11 +105,50 @@ func gcd(v, base, t int) (int, bool) {
 }
 
 func (m Raw) Write(f *os.File, tag string) {
+	m.WriteUnit(f, tag, time.Microsecond)
+}
+
+// WriteUnit writes a message with timings in units of unit. The timings
+// are written as microseconds, with a fraction if required.
+func (m Raw) WriteUnit(f *os.File, tag string, unit time.Duration) {
 	fmt.Fprintf(f, "%s", tag)
 	sep := ' '
 	for _, t := range m {
-		fmt.Fprintf(f, "%c%d", sep, t)
+		fmt.Fprintf(f, "%c%s", sep, formatTiming(time.Duration(t)*unit))
 		sep = ','
 	}
 	fmt.Fprint(f, "\n")
 }
+
+// formatTiming returns the timing as microseconds, with any
+// fraction of a microsecond as a decimal.
+func formatTiming(d time.Duration) string {
+	us := strconv.FormatInt(int64(d/time.Microsecond), 10)
+	ns := int64(d % time.Microsecond)
+	if ns == 0 {
+		return us
+	}
+	return us + "." + strings.TrimRight(fmt.Sprintf("%03d", ns), "0")
+}
+
+// parseTiming parses a timing in microseconds, with an optional fraction.
+// Timings cannot be negative, so a sign is not accepted.
+func parseTiming(s string) (time.Duration, error) {
+	us, frac, _ := strings.Cut(s, ".")
+	v, err := strconv.ParseUint(us, 10, 31)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(v) * time.Microsecond
+	if len(frac) > 0 {
+		if len(frac) > 3 {
+			return 0, fmt.Errorf("%s: resolution finer than a nanosecond", s)
+		}
+		ns, err := strconv.ParseUint(frac+strings.Repeat("0", 3-len(frac)), 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(ns)
+	}
+	return d, nil
+}
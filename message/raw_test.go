@@ -0,0 +1,69 @@
+package message
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTiming(t *testing.T) {
+	for _, tc := range []struct {
+		d time.Duration
+		s string
+	}{
+		{0, "0"},
+		{time.Nanosecond, "0.001"},
+		{999 * time.Nanosecond, "0.999"},
+		{412125 * time.Nanosecond, "412.125"},
+		{412100 * time.Nanosecond, "412.1"},
+		{412010 * time.Nanosecond, "412.01"},
+		{time.Millisecond, "1000"},
+		{time.Second + time.Nanosecond, "1000000.001"},
+	} {
+		if s := formatTiming(tc.d); s != tc.s {
+			t.Errorf("%d: formatted as %q, expected %q", tc.d, s, tc.s)
+		}
+		if d, err := parseTiming(tc.s); err != nil || d != tc.d {
+			t.Errorf("%q: parsed as %d (%v), expected %d", tc.s, d, err, tc.d)
+		}
+	}
+	// Trailing zeros in the fraction are accepted.
+	if d, err := parseTiming("412.100"); err != nil || d != 412100*time.Nanosecond {
+		t.Errorf("412.100: parsed as %d (%v)", d, err)
+	}
+	for _, s := range []string{"", ".5", "-5", "+5", "1.-5", "1.+5", "412.1234", "1e3", "x"} {
+		if _, err := parseTiming(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+// TestWriteUnit checks that messages written with sub-microsecond
+// timings are read back unchanged.
+func TestWriteUnit(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "msgs")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Raw{412125, 1000, 560001, 999, 9000000, 4500500}
+	m.WriteUnit(f, "ns", time.Nanosecond)
+	f.Close()
+	got, err := ReadTagFileUnit(name, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("ReadTagFileUnit: %v", err)
+	}
+	if !reflect.DeepEqual(got["ns"], []Raw{m}) {
+		t.Errorf("read %v, expected %v", got["ns"], m)
+	}
+	// Read as microseconds, the timings are rounded.
+	got, err = ReadTagFile(name)
+	if err != nil {
+		t.Fatalf("ReadTagFile: %v", err)
+	}
+	if want := (Raw{412, 1, 560, 1, 9000, 4501}); !reflect.DeepEqual(got["ns"], []Raw{want}) {
+		t.Errorf("read %v, expected %v", got["ns"], want)
+	}
+}
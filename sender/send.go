@@ -2,8 +2,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/aamcrae/rf/io"
@@ -15,31 +18,106 @@ var msg = flag.String("message", "", "Message name")
 var repeats = flag.Int("repeat", 3, "Number of repeats")
 var gap = flag.Int("gap", 10, "Inter-message gap (milliseconds)")
 var gpio = flag.Int("gpio", 15, "Output GPIO number") // PRU unit 0 P8_11
+var txType = flag.String("tx", io.DefaultBackend, "Transmitter type (pru, file:<name>, sim, emu, null)")
+var firmware = flag.String("firmware", "", "Optional file of PRU firmware to run")
+var outputs = flag.String("outputs", "", "Named transmitter outputs used by messages (name=gpio,...)")
+var stream = flag.Bool("stream", false, "Stream messages too long for the PRU RAM")
+var activeLow = flag.Bool("active-low", false, "Transmitter output is low while transmitting")
+var idleHigh = flag.Bool("idle-high", false, "Leave the transmitter output high between transmissions")
+var calibration = flag.String("calibration", "/etc/rf-calibration", "Timing calibration applied to captured messages, if present")
+var gapLevel = flag.String("gaplevel", "low", "Level of the gap between messages when they were captured (low, or high for inverted receivers)")
 
 func main() {
 	flag.Parse()
 
-	msgs, err := message.ReadTagFile(*file)
+	msgs, err := message.ReadTaggedFile(*file, time.Nanosecond)
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
 	log.Printf("%d messages read", len(msgs))
-	tx, err := io.NewTransmitter(uint(*gpio))
+	tx, err := io.OpenSink(*txType, uint(*gpio))
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
 	defer tx.Close()
+	if len(*firmware) > 0 {
+		if err := io.LoadFirmware(tx, *firmware); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	if *stream {
+		if err := io.SetStream(tx, true); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	if *activeLow || *idleHigh {
+		if err := io.SetPolarity(tx, io.Polarity{ActiveLow: *activeLow, IdleHigh: *idleHigh}); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	// Captured messages start at the level of the gap.
+	level, err := message.ParseGapLevel(*gapLevel)
+	if err != nil || level == message.GapAuto {
+		log.Fatalf("%s: gap level must be low or high", *gapLevel)
+	}
+	cal, err := io.ReadCalibration(*calibration)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("%s", err)
+	}
 	ml, ok := msgs[*msg]
 	if !ok {
 		log.Fatalf("%s: message not found", *msg)
 	}
+	outs, err := io.ParseOutputs(*outputs)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// The sinks for each output, with the default output named "".
+	sinks := map[string]io.Sink{"": tx}
+	for name, g := range outs {
+		if sinks[name], err = io.Output(tx, g); err != nil {
+			log.Fatalf("output %s: %v", name, err)
+		}
+	}
+	carriers := make([]io.Carrier, len(ml))
+	for i, m := range ml {
+		if carriers[i], err = io.ParseCarrier(m.Carrier); err != nil {
+			log.Fatalf("%s (%d): %v", *msg, i+1, err)
+		}
+		if _, ok := sinks[m.Output]; !ok {
+			log.Fatalf("%s (%d): unknown output %s", *msg, i+1, m.Output)
+		}
+	}
+	// Messages are sent as a sequence in one transmission,
+	// except where consecutive messages use different outputs.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var seq []io.Entry
+	var output string
 	for rep := 0; rep < *repeats; rep++ {
 		for i, m := range ml {
-			err = tx.Send(m, 1)
-			if err != nil {
-				log.Fatalf("%s (%d) repeat %d: %v", *msg, i+1, rep+1, err)
+			if m.Output != output && len(seq) > 0 {
+				send(ctx, sinks[output], seq)
+				seq = nil
+			}
+			output = m.Output
+			d := m.Raw.Durations(time.Nanosecond)
+			if len(m.IR) == 0 {
+				// Remove the timing errors of captured messages.
+				d = cal.Apply(d, level)
 			}
-			time.Sleep(time.Duration(*gap) * time.Millisecond)
+			seq = append(seq, io.Entry{Msg: d, Repeats: 1, Gap: time.Duration(*gap) * time.Millisecond, Carrier: carriers[i]})
 		}
 	}
+	send(ctx, sinks[output], seq)
+}
+
+// send sends a sequence of messages. If the sender is interrupted,
+// the transmission is aborted and the output left at the idle level.
+func send(ctx context.Context, s io.Sink, seq []io.Entry) {
+	res := io.SendSequenceAsync(ctx, s, seq).Wait()
+	if res.Err != nil {
+		log.Fatalf("%s: %v", *msg, res.Err)
+	}
+	log.Printf("%d messages sent in %s", len(seq), res.Duration)
 }
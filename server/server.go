@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/aamcrae/rf/io"
 	"github.com/aamcrae/rf/message"
+	"github.com/aamcrae/rf/verify"
 )
 
 var port = flag.Int("port", 8080, "Web server port number")
@@ -18,22 +20,130 @@ var messages = flag.String("messages", "/etc/rf-messages", "File containing mess
 var verbose = flag.Bool("v", false, "Log more information")
 var repeats = flag.Int("repeats", 1, "Number of message repeats")
 var gap = flag.Int("gap", 10, "Inter-message gap")
+var txType = flag.String("tx", io.DefaultBackend, "Transmitter type (pru, file:<name>, sim, emu, null)")
+var firmware = flag.String("firmware", "", "Optional file of PRU firmware to run")
+var listen = flag.Bool("listen", false, "Log received messages")
+var rxType = flag.String("rx", io.DefaultBackend, "Receiver type (pru, file:<name>, sim, emu:<name>, null)")
+var rxGpio = flag.Int("rxgpio", 5, "Receiver input GPIO number")
+var rxGapLevel = flag.String("rxgaplevel", "low", "Level of the gap between received and captured messages (low, high for inverted receivers, or auto)")
+var duplex = flag.String("duplex", "gate", "Handling of own transmissions when listening (gate or tag)")
+var verifySent = flag.Bool("verify", false, "Check that each message sent is heard by the receiver (requires -duplex tag)")
+var guard = flag.Int("guard", 20, "Guard time after transmitting (milliseconds)")
+var tolerance = flag.Int("tolerance", 20, "Percent tolerance when matching received messages")
+var outputs = flag.String("outputs", "", "Named transmitter outputs used by messages (name=gpio,...)")
+var stream = flag.Bool("stream", false, "Stream messages too long for the PRU RAM")
+var activeLow = flag.Bool("active-low", false, "Transmitter output is low while transmitting")
+var idleHigh = flag.Bool("idle-high", false, "Leave the transmitter output high between transmissions")
+var calibration = flag.String("calibration", "/etc/rf-calibration", "Timing calibration applied to captured messages, if present")
+var duty = flag.Float64("duty", 0, "Maximum percentage of the duty window spent transmitting (0 for no limit)")
+var window = flag.Duration("window", time.Hour, "Duty cycle window")
+var lbt = flag.Bool("lbt", false, "Defer transmitting while the receiver hears another transmitter")
 
 func main() {
 	flag.Parse()
-	tx, err := io.NewTransmitter(uint(*gpio))
+	tx, err := io.OpenSink(*txType, uint(*gpio))
 	if err != nil {
-		log.Fatalf("NewTransmitter: %v", err)
+		log.Fatalf("OpenSink: %v", err)
 	}
-	msgs, err := message.ReadTagFile(*messages)
+	if len(*firmware) > 0 {
+		if err := io.LoadFirmware(tx, *firmware); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *stream {
+		if err := io.SetStream(tx, true); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *activeLow || *idleHigh {
+		if err := io.SetPolarity(tx, io.Polarity{ActiveLow: *activeLow, IdleHigh: *idleHigh}); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	// Messages are read in nanoseconds to keep the resolution of captured timings.
+	tagged, err := message.ReadTaggedFile(*messages, time.Nanosecond)
 	if err != nil {
 		log.Fatalf("%s: %v", *messages, err)
 	}
-	for tag, m := range msgs {
+	cal, err := io.ReadCalibration(*calibration)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("%v", err)
+	}
+	level, err := message.ParseGapLevel(*rxGapLevel)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// Captured messages start at the level of the gap, which is
+	// assumed to be low if it is detected.
+	capLevel := level
+	if capLevel == message.GapAuto {
+		capLevel = message.GapLow
+	}
+	outs, err := io.ParseOutputs(*outputs)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// The sinks for each output, with the default output named "".
+	sinks := map[string]io.Sink{"": tx}
+	for name, g := range outs {
+		if sinks[name], err = io.Output(tx, g); err != nil {
+			log.Fatalf("output %s: %v", name, err)
+		}
+	}
+	msgs := make(map[string][]message.Raw)
+	for tag, tl := range tagged {
+		for _, t := range tl {
+			if _, ok := sinks[t.Output]; !ok {
+				log.Fatalf("%s: message %s: unknown output %s", *messages, tag, t.Output)
+			}
+			if _, err := io.ParseCarrier(t.Carrier); err != nil {
+				log.Fatalf("%s: message %s: %v", *messages, tag, err)
+			}
+			msgs[tag] = append(msgs[tag], t.Raw)
+		}
+	}
+	var v *verify.Verifier
+	var mon *io.ChannelMonitor
+	if *listen || *verifySent || *lbt {
+		if *duplex != "gate" && *duplex != "tag" {
+			log.Fatalf("%s: unknown duplex mode", *duplex)
+		}
+		if *verifySent {
+			if *duplex == "gate" {
+				log.Fatalf("Verifying messages requires -duplex tag, so that they are heard")
+			}
+			v = verify.New(*tolerance)
+		}
+		rx, err := io.OpenSource(*rxType, uint(*rxGpio))
+		if err != nil {
+			log.Fatalf("OpenSource: %v", err)
+		}
+		defer rx.Close()
+		if *lbt {
+			mon = io.NewChannelMonitor()
+		}
+		d := io.NewDuplex(time.Duration(*guard) * time.Millisecond)
+		for name, s := range sinks {
+			// Transmissions are deferred before they are tracked as own transmissions.
+			sinks[name] = d.Sink(s)
+			if mon != nil {
+				sinks[name] = mon.Sink(sinks[name])
+			}
+		}
+		src := d.Source(rx, *duplex == "gate")
+		c, err := src.Start()
+		if err != nil {
+			log.Fatalf("Receiver start: %v", err)
+		}
+		go receiver(c, src, d, v, mon, level, msgs)
+	}
+	q := io.NewQueue(*duty/100, *window)
+	defer q.Close()
+	for tag, m := range tagged {
 		if *verbose {
 			log.Printf("Message %s, count %d", tag, len(m))
 		}
-		http.Handle(fmt.Sprintf("/tx/%s", tag), http.HandlerFunc(handler(tx, tag, m)))
+		http.Handle(fmt.Sprintf("/tx/%s", tag), http.HandlerFunc(handler(q, sinks, v, mon, cal, capLevel, tag, m)))
 	}
 	url := fmt.Sprintf(":%d", *port)
 	if *verbose {
@@ -43,17 +153,123 @@ func main() {
 	log.Fatal(server.ListenAndServe())
 }
 
-func handler(tx *io.Transmitter, tag string, msg []message.Raw) func(http.ResponseWriter, *http.Request) {
+// handler queues the messages for a tag. The priority may be set
+// in the request as ?priority=low or ?priority=high.
+// The calibration is applied to messages that were captured, which
+// start at capLevel.
+// If the verifier is set, the result of checking each message
+// is logged and returned in the response, which is delayed by
+// the verifier's settle time.
+// If the channel monitor is set, the transmission deferrals are logged.
+func handler(q *io.Queue, sinks map[string]io.Sink, v *verify.Verifier, mon *io.ChannelMonitor, cal io.Calibration, capLevel int, tag string, msg []message.Tagged) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		pri := io.PriorityNormal
+		switch r.URL.Query().Get("priority") {
+		case "low":
+			pri = io.PriorityLow
+		case "high":
+			pri = io.PriorityHigh
+		}
 		if *verbose {
 			log.Printf("Sending tag %s %d messages", tag, len(msg))
 		}
+		// Consecutive messages on the same output are sent as one sequence.
+		var seq []io.Entry
 		for i, m := range msg {
-			err := tx.Send(m, *repeats)
-			if err != nil {
-				log.Printf("Message %d: %v", tag, i, err)
+			// The carrier has been checked when the messages were read.
+			c, _ := io.ParseCarrier(m.Carrier)
+			d := m.Raw.Durations(time.Nanosecond)
+			if len(m.IR) == 0 {
+				d = cal.Apply(d, capLevel)
+			}
+			seq = append(seq, io.Entry{Msg: d, Repeats: *repeats, Gap: time.Duration(*gap) * time.Millisecond, Carrier: c})
+			if i == len(msg)-1 || msg[i+1].Output != m.Output {
+				// The transmission is aborted if the client goes away.
+				res := q.Send(r.Context(), sinks[m.Output], seq, pri).Wait()
+				if res.Err != nil {
+					log.Printf("%s: message %d: %v", tag, i, res.Err)
+				} else {
+					if *verbose {
+						st := q.Stats()
+						log.Printf("%s: sent %d messages in %s (queue depth %d, max wait %s, busy %s)", tag, len(seq), res.Duration, st.Depth, st.MaxWait, st.Busy)
+						if mon != nil {
+							cs := mon.Stats()
+							log.Printf("%s: channel busy for %d of %d transmissions (%d sent anyway), deferred %s total, %s max", tag, cs.Deferred, cs.Checked, cs.Forced, cs.Delay, cs.MaxDelay)
+						}
+					}
+					if v != nil {
+						end := time.Now()
+						start := end.Add(-res.Duration)
+						for j := i + 1 - len(seq); j <= i; j++ {
+							vr := v.Check(msg[j].Raw, start, end)
+							log.Printf("%s: message %d: %s", tag, j, vr)
+							fmt.Fprintf(w, "message %d: %s\n", j, vr)
+						}
+					}
+				}
+				seq = nil
 			}
-			time.Sleep(time.Duration(*gap) * time.Millisecond)
+		}
+	}
+}
+
+// receiver logs the messages received, identifying those
+// matching a known message, and those sent by this server.
+// The messages are passed to the verifier if it is set.
+// Timings that are not from own transmissions are passed to
+// the channel monitor if it is set.
+func receiver(c <-chan time.Duration, src *io.DuplexSource, d *io.Duplex, verifier *verify.Verifier, mon *io.ChannelMonitor, level int, msgs map[string][]message.Raw) {
+	l := message.NewPreciseListener(time.Nanosecond)
+	l.GapLevel = level
+	// The last message is completed once the receiver has been idle
+	// for longer than the gap, rather than when the next edge arrives.
+	idle := time.NewTicker(l.Timeout())
+	defer idle.Stop()
+	for {
+		var m message.Raw
+		select {
+		case t, ok := <-c:
+			if !ok {
+				return
+			}
+			if mon != nil {
+				end := src.Time()
+				if !d.Active(end.Add(-t), end) {
+					mon.Add(t, end)
+				}
+			}
+			m = l.NextDuration(t)
+		case <-idle.C:
+			m = l.IdleDuration(src.Idle())
+		}
+		if m == nil {
+			continue
+		}
+		end := src.Time()
+		if verifier != nil {
+			verifier.Heard(m, end)
+		}
+		if !*listen {
+			continue
+		}
+		var length time.Duration
+		for _, v := range m {
+			length += time.Duration(v)
+		}
+		tag := "unknown"
+	search:
+		for name, ml := range msgs {
+			for _, r := range ml {
+				if len(r) == len(m) && r.Equal(m, *tolerance) == len(r) {
+					tag = name
+					break search
+				}
+			}
+		}
+		if d.Active(end.Add(-length), end) {
+			log.Printf("Received %s message (own transmission), %d timings", tag, len(m))
+		} else {
+			log.Printf("Received %s message, %d timings", tag, len(m))
 		}
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"text/scanner"
 	"time"
@@ -17,7 +18,7 @@ import (
 
 var tolerance = flag.Int("tolerance", 20, "Percent tolerance")
 var referenceFile = flag.String("messages", "", "Database of RF messages for reference")
-var gap = flag.Int("gap", 4000, "Mininum number of changes")
+var gap = flag.Int("gap", 0, "Minimum gap between messages in microseconds (default 4000, or 10000 with -ir)")
 var min_msg = flag.Int("min", 10, "Mininum number of changes")
 var max_msg = flag.Int("max", 140, "Maximum number of changes")
 var base_time = flag.Int("base", 0, "Microseconds for bit period")
@@ -27,50 +28,135 @@ var input = flag.String("input", "", "Input file to be read")
 var debounce = flag.Int("debounce", 100, "Minimum time for transition")
 var tag = flag.String("tag", "tag", "Message tag for output")
 var output = flag.String("output", "", "Output filename")
+var rxType = flag.String("rx", io.DefaultBackend, "Receiver type for capture (pru, file:<name>, sim, emu:<name>, null; with -gpios pru, emu:<name>,<name>, null)")
+var firmware = flag.String("firmware", "", "Optional file of PRU firmware to run")
+var precise = flag.Bool("precise", false, "Keep sub-microsecond resolution of captured timings")
+var gpios = flag.String("gpios", "", "Comma separated input GPIO numbers to capture from at once")
+var gapLevel = flag.String("gaplevel", "low", "Level of the gap between messages (low, high for inverted receivers, or auto)")
+var ir = flag.Bool("ir", false, "Decode messages as infrared remote control commands")
 
 type msg struct {
 	base     message.Base
 	messages []message.Raw
 }
 
+// msgKey groups the messages by input channel and length.
+type msgKey struct {
+	channel int
+	length  int
+}
+
+// captured is a message received on a channel.
+type captured struct {
+	channel int
+	m       message.Raw
+}
+
+const defaultGap = 4000 // Default gap between messages in microseconds
+const irGap = 10000     // Default gap between IR messages in microseconds
+
 var tags map[string][]message.Raw
-var lenMap = make(map[int]*msg)
-var messages []message.Raw
+var lenMap = make(map[msgKey]*msg)
+var messages []captured
+var multi bool
 var baseAll message.Base
+var unit = time.Microsecond
+var scale = 1 // Number of units in a microsecond
 
 func main() {
 	flag.Parse()
+	if *precise {
+		unit = time.Nanosecond
+		scale = int(time.Microsecond / unit)
+	}
 	baseAll.Tolerance = *tolerance
 	if len(*referenceFile) > 0 {
 		var err error
-		tags, err = message.ReadTagFile(*referenceFile)
+		tags, err = message.ReadTagFileUnit(*referenceFile, unit)
 		if err != nil {
 			log.Fatalf("%s: %v", *referenceFile, err)
 		}
 	}
-	l := message.NewListener()
-	l.Gap = *gap
-	l.MinLen = *min_msg
-	l.MaxLen = *max_msg
-	l.MinPulse = *debounce
+	var listeners []*message.Listener
 	if len(*input) > 0 {
+		l := newListener()
 		readFromFile(*input, l)
+		listeners = append(listeners, l)
+	} else if len(*gpios) > 0 {
+		multi = true
+		listeners = captureMulti(parseGpios(*gpios))
 	} else {
+		l := newListener()
 		capture(l)
+		listeners = append(listeners, l)
+	}
+	for ch, l := range listeners {
+		if multi {
+			fmt.Printf("Channel %d: ", ch)
+		}
+		fmt.Printf("Noise skipped msgs = %d, overflow = %d, runts = %d, lost = %d, min timing = %d\n", l.Noise, l.Overflow, l.Runt, l.Lost, l.ShortestPulse/scale)
+		if l.GapLevel == message.GapAuto {
+			switch l.Level() {
+			case message.GapLow:
+				fmt.Printf("Gap level detected as low\n")
+			case message.GapHigh:
+				fmt.Printf("Gap level detected as high\n")
+			default:
+				fmt.Printf("Gap level not detected\n")
+			}
+		}
 	}
-	fmt.Printf("Noise skipped msgs = %d, overflow = %d, runts = %d, min timing = %d\n", l.Noise, l.Overflow, l.Runt, l.ShortestPulse)
 	if len(*output) > 0 {
 		f, err := os.OpenFile(*output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer f.Close()
-		for _, m := range messages {
-			m.Write(f, *tag)
+		for _, c := range messages {
+			t := *tag
+			if multi {
+				t = fmt.Sprintf("%s-%d", *tag, c.channel)
+			}
+			c.m.WriteUnit(f, t, unit)
 		}
 	}
 }
 
+func newListener() *message.Listener {
+	l := message.NewPreciseListener(unit)
+	g := *gap
+	if g == 0 {
+		// The NEC header has a space of 4500us, so IR messages
+		// need a longer gap than the usual default.
+		g = defaultGap
+		if *ir {
+			g = irGap
+		}
+	}
+	l.Gap = g * scale
+	l.MinLen = *min_msg
+	l.MaxLen = *max_msg
+	l.MinPulse = *debounce * scale
+	level, err := message.ParseGapLevel(*gapLevel)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	l.GapLevel = level
+	return l
+}
+
+func parseGpios(s string) []uint {
+	var g []uint
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			log.Fatalf("%s: bad GPIO number: %v", v, err)
+		}
+		g = append(g, uint(n))
+	}
+	return g
+}
+
 func readFromFile(input string, l *message.Listener) {
 	f, err := os.Open(input)
 	if err != nil {
@@ -87,20 +173,25 @@ func readFromFile(input string, l *message.Listener) {
 			if err != nil {
 				log.Fatalf("Illegal value: %s: %v\n", s.TokenText(), err)
 			}
-			m := l.Next(int(v))
+			m := l.Next(int(v) * scale)
 			if m != nil {
-				newMessage(m)
+				newMessage(0, m)
 			}
 		}
 	}
 }
 
 func capture(l *message.Listener) {
-	inp, err := io.NewReceiver(uint(*gpio))
+	inp, err := io.OpenSource(*rxType, uint(*gpio))
 	if err != nil {
 		log.Fatalf("GPIO %d receiver failed: %v", *gpio, err)
 	}
 	defer inp.Close()
+	if len(*firmware) > 0 {
+		if err := io.LoadFirmware(inp, *firmware); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
 	c, err := inp.Start()
 	if err != nil {
 		log.Fatalf("GPIO %d receiver failed: %v", *gpio, err)
@@ -108,39 +199,126 @@ func capture(l *message.Listener) {
 	fmt.Printf("Starting Capture - hit enter to exit\n")
 	var wg sync.WaitGroup
 	wg.Add(1)
-	go reader(c, &wg, l)
+	go reader(c, &wg, l, io.NewClock(inp))
 	fmt.Scanln()
 	inp.Stop()
 	wg.Wait()
 	fmt.Printf("Capture finished\n")
+	if s, ok := inp.(interface{ Stats() io.RxStats }); ok {
+		st := s.Stats()
+		fmt.Printf("Receiver buffers = %d, overflows = %d\n", st.Buffers, st.Overflows)
+	}
 }
 
-func reader(c <-chan time.Duration, wg *sync.WaitGroup, l *message.Listener) {
+func reader(c <-chan time.Duration, wg *sync.WaitGroup, l *message.Listener, clock *io.Clock) {
+	// The last message is completed once the receiver has been idle.
+	// The idle time is measured from the end of the last timing rather
+	// than when it was read, since the receiver may deliver it late.
+	idle := time.NewTicker(l.Timeout())
+	defer idle.Stop()
 	for {
-		d := <-c
-		if d == 0 {
-			wg.Done()
-			return
+		var m message.Raw
+		select {
+		case d := <-c:
+			if d == 0 {
+				wg.Done()
+				return
+			}
+			clock.Add(d)
+			m = l.NextDuration(d)
+		case <-idle.C:
+			m = l.IdleDuration(clock.Idle())
 		}
-		m := l.Next(int(d.Microseconds()))
 		if m != nil {
-			newMessage(m)
+			newMessage(0, m)
 		}
 	}
 }
 
-func newMessage(m message.Raw) {
+// captureMulti captures from several inputs, with a Listener for each.
+func captureMulti(g []uint) []*message.Listener {
+	inp, err := io.OpenMultiSource(*rxType, g)
+	if err != nil {
+		log.Fatalf("GPIO %v receiver failed: %v", g, err)
+	}
+	defer inp.Close()
+	if len(*firmware) > 0 {
+		if err := io.LoadFirmware(inp, *firmware); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	c, err := inp.Start()
+	if err != nil {
+		log.Fatalf("GPIO %v receiver failed: %v", g, err)
+	}
+	var listeners []*message.Listener
+	for range g {
+		listeners = append(listeners, newListener())
+	}
+	fmt.Printf("Starting Capture on %d inputs - hit enter to exit\n", len(g))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The last message on each input is completed once the input has been idle.
+		idle := time.NewTicker(listeners[0].Timeout())
+		defer idle.Stop()
+		var clocks []*io.Clock
+		for range listeners {
+			clocks = append(clocks, io.NewClock(inp))
+		}
+		for {
+			select {
+			case t, ok := <-c:
+				if !ok {
+					return
+				}
+				clocks[t.Channel].Add(t.Duration)
+				m := listeners[t.Channel].NextDuration(t.Duration)
+				if m != nil {
+					newMessage(t.Channel, m)
+				}
+			case <-idle.C:
+				for ch, l := range listeners {
+					if m := l.IdleDuration(clocks[ch].Idle()); m != nil {
+						newMessage(ch, m)
+					}
+				}
+			}
+		}
+	}()
+	fmt.Scanln()
+	inp.Stop()
+	wg.Wait()
+	fmt.Printf("Capture finished\n")
+	if s, ok := inp.(interface{ Stats() io.RxStats }); ok {
+		st := s.Stats()
+		fmt.Printf("Receiver buffers = %d, overflows = %d\n", st.Buffers, st.Overflows)
+	}
+	return listeners
+}
+
+func newMessage(ch int, m message.Raw) {
 	baseAll.Add(m)
 	l := len(m)
-	mp, ok := lenMap[l]
+	key := msgKey{ch, l}
+	mp, ok := lenMap[key]
 	if !ok {
 		mp = new(msg)
 		mp.base.Tolerance = *tolerance
-		lenMap[l] = mp
+		lenMap[key] = mp
 	}
 	mp.messages = append(mp.messages, m)
-	messages = append(messages, m)
+	messages = append(messages, captured{ch, m})
 	mp.base.Add(m)
-	base, quality := mp.base.EstimateBase(*round)
-	fmt.Printf("len %d, %d messages, estimated base %d (quality %d)\n", l, len(mp.messages), base, quality)
+	base, quality := mp.base.EstimateBase(*round * scale)
+	if multi {
+		fmt.Printf("channel %d: ", ch)
+	}
+	fmt.Printf("len %d, %d messages, estimated base %d (quality %d)\n", l, len(mp.messages), base/scale, quality)
+	if *ir {
+		if c, err := message.DecodeIR(m, unit); err == nil {
+			fmt.Printf("IR command %s\n", c)
+		}
+	}
 }
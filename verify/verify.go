@@ -0,0 +1,120 @@
+// Verification that transmitted messages are heard by a receiver.
+
+package verify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aamcrae/rf/message"
+)
+
+const verifyHistory = time.Minute // How long heard messages are remembered
+
+// Verdict is the outcome of checking that a message was sent correctly.
+type Verdict int
+
+const (
+	NotHeard Verdict = iota // No message was heard while sending
+	Partial                 // Messages were heard, but none matched
+	Verified                // The message was heard
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Verified:
+		return "verified"
+	case Partial:
+		return "partial match"
+	}
+	return "not heard"
+}
+
+// Verification is the result of checking a sent message against
+// the messages heard by the receiver.
+type Verification struct {
+	Verdict Verdict
+	Heard   int // Messages heard while sending
+	Matched int // Messages heard that matched the message sent
+	Best    int // Most timings matching in a heard message
+	Len     int // Number of timings compared
+}
+
+func (r Verification) String() string {
+	if r.Verdict == Verified {
+		return fmt.Sprintf("%s (%d of %d messages heard matched)", r.Verdict, r.Matched, r.Heard)
+	}
+	return fmt.Sprintf("%s (%d messages heard, best match %d of %d timings)", r.Verdict, r.Heard, r.Best, r.Len)
+}
+
+// Verifier checks that the messages sent by a transmitter are heard by
+// a receiver, by comparing the messages heard during the transmission
+// with the messages sent. The receiver must not discard the timings
+// received while transmitting (i.e. a gated DuplexSource cannot be used).
+type Verifier struct {
+	Tolerance int           // Percent tolerance of the heard timings
+	Settle    time.Duration // Time allowed after a transmission for the receiver to deliver the message
+	lock      sync.Mutex
+	heard     []heardMsg
+}
+
+// heardMsg is a message heard by the receiver.
+type heardMsg struct {
+	m    message.Raw
+	when time.Time
+}
+
+func New(tolerance int) *Verifier {
+	return &Verifier{Tolerance: tolerance, Settle: 250 * time.Millisecond}
+}
+
+// Heard records a message heard by the receiver at the time given.
+func (v *Verifier) Heard(m message.Raw, when time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	// Discard old messages.
+	h := v.heard[:0]
+	for _, hm := range v.heard {
+		if when.Sub(hm.when) < verifyHistory {
+			h = append(h, hm)
+		}
+	}
+	v.heard = append(h, heardMsg{m: m, when: when})
+}
+
+// Check compares a message sent in a transmission between start and end with
+// the messages heard from start until the settle time after the end.
+// Check sleeps until the settle time has passed, so the caller (such as
+// an HTTP handler) is held up for the settle time after each transmission.
+func (v *Verifier) Check(msg message.Raw, start, end time.Time) Verification {
+	time.Sleep(time.Until(end.Add(v.Settle)))
+	// A Listener does not return the first high period of a
+	// message, so it is not compared.
+	if len(msg) > 0 {
+		msg = msg[1:]
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	r := Verification{Len: len(msg)}
+	for _, hm := range v.heard {
+		if hm.when.Before(start) || hm.when.After(end.Add(v.Settle)) {
+			continue
+		}
+		r.Heard++
+		n := msg.Equal(hm.m, v.Tolerance)
+		if n == len(msg) {
+			r.Matched++
+		}
+		if n > r.Best {
+			r.Best = n
+		}
+	}
+	switch {
+	case r.Matched > 0:
+		r.Verdict = Verified
+	case r.Heard > 0:
+		r.Verdict = Partial
+	}
+	return r
+}
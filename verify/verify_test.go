@@ -0,0 +1,27 @@
+package verify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aamcrae/rf/message"
+)
+
+func TestCheck(t *testing.T) {
+	v := New(10)
+	v.Settle = 10 * time.Millisecond
+	msg := message.Raw{500, 1000, 500, 1000, 500, 1000, 500}
+	// The transmissions are in the past, so that Check does not wait.
+	start := time.Now().Add(-10 * time.Second)
+	v.Heard(message.Raw{1000, 500, 1000, 500, 1000, 500}, start.Add(time.Millisecond))
+	if r := v.Check(msg, start, start.Add(2*time.Millisecond)); r.Verdict != Verified || r.Matched != 1 {
+		t.Errorf("matching message: %s", r)
+	}
+	v.Heard(message.Raw{1000, 500, 1000, 900, 1000, 500}, start.Add(time.Second))
+	if r := v.Check(msg, start.Add(time.Second), start.Add(time.Second)); r.Verdict != Partial || r.Best != 5 {
+		t.Errorf("mismatched message: %s", r)
+	}
+	if r := v.Check(msg, start.Add(5*time.Second), start.Add(5*time.Second)); r.Verdict != NotHeard {
+		t.Errorf("no message: %s", r)
+	}
+}